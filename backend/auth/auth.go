@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"backend/signing"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+///////////////////////////////////////
+// ACCESS AND REFRESH TOKENS
+// An access token is a short-lived JWT signed with HS256 that names the
+// user. Anything that needs to know who is calling verifies it without a
+// database lookup. A refresh token is an opaque random string whose hash
+// is stored, so it can be exchanged for a new pair once and revoked.
+///////////////////////////////////////
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrMissingToken    = errors.New("missing bearer token")
+	ErrInvalidToken    = errors.New("invalid access token")
+	ErrTokenExpired    = errors.New("access token has expired")
+	ErrInvalidUsername = errors.New("username must be 3 to 32 letters, digits, _ or -, and not bot")
+	ErrInvalidPassword = errors.New("password must be 8 to 72 bytes long")
+)
+
+type Claims struct {
+	Subject   string `json:"sub"` // username
+	UserID    int64  `json:"uid"`
+	Guest     bool   `json:"guest,omitempty"`
+	IssuedAt  int64  `json:"iat"` // unix seconds
+	ExpiresAt int64  `json:"exp"`
+}
+
+type Issuer struct {
+	key        signing.Key
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Tokens signs and checks access tokens. The server replaces it with one
+// built from its config at startup.
+var Tokens = NewIssuer(nil, DefaultAccessTTL, DefaultRefreshTTL)
+
+// jwtHeader is the only header this package issues or accepts.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+///////////////////////////////////////
+// NewIssuer signs with secret. A TTL that is not positive is replaced by
+// its default.
+///////////////////////////////////////
+
+func NewIssuer(secret []byte, accessTTL, refreshTTL time.Duration) Issuer {
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTTL
+	}
+	return Issuer{key: signing.NewKey(secret), AccessTTL: accessTTL, RefreshTTL: refreshTTL}
+}
+
+func (i Issuer) AccessToken(userID int64, username string, guest bool, now time.Time) (string, Claims) {
+	claims := Claims{
+		Subject:   username,
+		UserID:    userID,
+		Guest:     guest,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(i.AccessTTL).Unix(),
+	}
+	signed := jwtHeader + "." + signing.Encode(claims)
+	return signed + "." + i.key.Sign(signed), claims
+}
+
+///////////////////////////////////////
+// Verify checks an access token's header, signature and expiry and
+// returns its claims.
+///////////////////////////////////////
+
+func (i Issuer) Verify(token string, now time.Time) (Claims, error) {
+	if token == "" {
+		return Claims{}, ErrMissingToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return Claims{}, ErrInvalidToken
+	}
+	signed := parts[0] + "." + parts[1]
+	if !i.key.Verify(signed, parts[2]) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := signing.Decode(parts[1], &claims); err != nil || claims.Subject == "" {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+///////////////////////////////////////
+// NewRefreshToken returns a random refresh token and the hash to store
+// for it. The token itself is only ever given to the client.
+///////////////////////////////////////
+
+func NewRefreshToken() (string, string) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		panic("auth: cannot generate a refresh token: " + err.Error())
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashRefreshToken(token)
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+///////////////////////////////////////
+// BearerToken returns the access token a request carries, from the
+// Authorization header or, for websockets, which browsers cannot give
+// headers, the access_token query parameter.
+///////////////////////////////////////
+
+func BearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+///////////////////////////////////////
+// PASSWORDS AND USERNAMES
+///////////////////////////////////////
+
+func HashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyHash is compared against when there is no user, so a login takes
+// as long whether or not the username exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("connect four dummy password"), bcrypt.DefaultCost)
+
+// CheckPassword reports whether password matches hash. An empty hash,
+// as guests have, never matches.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func ValidatePassword(password string) error {
+	// bcrypt ignores everything after 72 bytes
+	if len(password) < 8 || len(password) > 72 {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+func ValidateUsername(username string) error {
+	if len(username) < 3 || len(username) > 32 || strings.EqualFold(username, "bot") {
+		return ErrInvalidUsername
+	}
+	for _, c := range username {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return ErrInvalidUsername
+		}
+	}
+	return nil
+}
+
+// GuestUsername returns a fresh name for a guest who did not choose one.
+func GuestUsername() string {
+	raw := make([]byte, 4)
+	rand.Read(raw)
+	return "guest_" + hex.EncodeToString(raw)
+}
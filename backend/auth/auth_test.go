@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"backend/signing"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	token, issued := NewIssuer(testSecret, time.Minute, time.Hour).AccessToken(42, "alice", true, now)
+
+	// An issuer built from the same secret, as after a restart, accepts it.
+	claims, err := NewIssuer(testSecret, time.Minute, time.Hour).Verify(token, now.Add(59*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: "alice", UserID: 42, Guest: true, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	if claims != want || issued != want {
+		t.Errorf("Verify = %+v, AccessToken = %+v, want %+v", claims, issued, want)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	issuer := NewIssuer(testSecret, time.Minute, time.Hour)
+	now := time.Unix(1_700_000_000, 0)
+	token, claims := issuer.AccessToken(42, "alice", false, now)
+	parts := strings.Split(token, ".")
+
+	// Another user's claims under alice's signature.
+	stolen := claims
+	stolen.Subject = "mallory"
+
+	// Headers other than the one this package issues, each signed with the
+	// right secret so only the header is wrong.
+	resign := func(header string) string {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(header))
+		signed := encoded + "." + parts[1]
+		return signed + "." + signing.NewKey(testSecret).Sign(signed)
+	}
+
+	tests := []struct {
+		name   string
+		issuer Issuer
+		token  string
+		now    time.Time
+		want   error
+	}{
+		{"missing", issuer, "", now, ErrMissingToken},
+		{"tampered signature", issuer, parts[0] + "." + parts[1] + "." + parts[2][:len(parts[2])-1] + "A", now, ErrInvalidToken},
+		{"tampered claims", issuer, parts[0] + "." + signing.Encode(stolen) + "." + parts[2], now, ErrInvalidToken},
+		{"no signature", issuer, parts[0] + "." + parts[1], now, ErrInvalidToken},
+		{"other algorithm", issuer, resign(`{"alg":"HS512","typ":"JWT"}`), now, ErrInvalidToken},
+		{"unsigned", issuer, resign(`{"alg":"none","typ":"JWT"}`), now, ErrInvalidToken},
+		{"unknown header", issuer, resign(`{"typ":"JWT","alg":"HS256"}`), now, ErrInvalidToken},
+		{"other secret", NewIssuer([]byte("fedcba9876543210fedcba9876543210"), time.Minute, time.Hour), token, now, ErrInvalidToken},
+		{"random secret", NewIssuer(nil, time.Minute, time.Hour), token, now, ErrInvalidToken},
+		{"expired", issuer, token, now.Add(time.Minute), ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		if _, err := tt.issuer.Verify(tt.token, tt.now); err != tt.want {
+			t.Errorf("%s: Verify error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package bot
+
+import (
+	"backend/game"
+	"math/bits"
+	"math/rand"
+	"time"
+)
+
+///////////////////////////////////////
+// DIFFICULTY LEVELS
+///////////////////////////////////////
+
+type Difficulty string
+
+const (
+	Easy    Difficulty = "easy"
+	Medium  Difficulty = "medium"
+	Hard    Difficulty = "hard"
+	Perfect Difficulty = "perfect"
+
+	DefaultDifficulty = Medium
+)
+
+// Difficulties lists every level, easiest first.
+var Difficulties = []Difficulty{Easy, Medium, Hard, Perfect}
+
+// Settings bound how hard the engine tries at a given difficulty.
+type Settings struct {
+	MaxDepth   int           // deepest iteration of the iterative deepening loop
+	TimeBudget time.Duration // wall-clock budget for a single move
+	Blunder    float64       // chance of playing a random legal move instead
+	TableBits  uint          // transposition table holds 1<<TableBits entries
+}
+
+var levels = map[Difficulty]Settings{
+	Easy:    {MaxDepth: 2, TimeBudget: 100 * time.Millisecond, Blunder: 0.3, TableBits: 10},
+	Medium:  {MaxDepth: 6, TimeBudget: 300 * time.Millisecond, Blunder: 0.05, TableBits: 14},
+	Hard:    {MaxDepth: 12, TimeBudget: 1 * time.Second, TableBits: 18},
+	Perfect: {MaxDepth: game.MaxMoves, TimeBudget: 3 * time.Second, TableBits: 20},
+}
+
+///////////////////////////////////////
+// ParseDifficulty converts a client supplied level. An empty string selects
+// DefaultDifficulty.
+///////////////////////////////////////
+
+func ParseDifficulty(s string) (Difficulty, bool) {
+	if s == "" {
+		return DefaultDifficulty, true
+	}
+	d := Difficulty(s)
+	_, ok := levels[d]
+	return d, ok
+}
+
+func (d Difficulty) Settings() Settings {
+	if settings, ok := levels[d]; ok {
+		return settings
+	}
+	return levels[DefaultDifficulty]
+}
+
+///////////////////////////////////////
+// SCORES
+// Wins are scored so that quicker wins (fewer discs on the board) rank higher.
+///////////////////////////////////////
+
+const (
+	winScore = 1_000_000
+	infinity = winScore + game.MaxMoves + 1
+)
+
+// columnOrder searches the center columns first, which prunes far better.
+var columnOrder = [game.Columns]int{3, 2, 4, 1, 5, 0, 6}
+
+///////////////////////////////////////
+// ENGINE
+// Negamax with alpha-beta pruning, a transposition table and iterative
+// deepening under a time budget. An Engine keeps its table between moves and
+// is not safe for concurrent use.
+///////////////////////////////////////
+
+type Engine struct {
+	Difficulty Difficulty
+	settings   Settings
+	table      *transpositionTable
+	rng        *rand.Rand
+
+	deadline time.Time
+	nodes    int
+	aborted  bool
+}
+
+func NewEngine(difficulty Difficulty) *Engine {
+	settings := difficulty.Settings()
+	return &Engine{
+		Difficulty: difficulty,
+		settings:   settings,
+		table:      newTranspositionTable(settings.TableBits),
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+///////////////////////////////////////
+// BestMove returns the column to play for the side to move, or -1 if the
+// game is over. The board is left unchanged.
+///////////////////////////////////////
+
+func (e *Engine) BestMove(b *game.Board) int {
+	return e.BestMoveWithin(b, e.settings.TimeBudget)
+}
+
+///////////////////////////////////////
+// BestMoveWithin is BestMove thinking for no longer than budget, or the
+// level's TimeBudget if that is shorter. A game clock sets the budget.
+///////////////////////////////////////
+
+func (e *Engine) BestMoveWithin(b *game.Board, budget time.Duration) int {
+	legal := b.LegalMoves()
+	if len(legal) == 0 {
+		return -1
+	}
+	if len(legal) == 1 {
+		return legal[0]
+	}
+
+	// Never miss an immediate win, even when blundering.
+	for _, col := range legal {
+		if b.WinsAt(col, b.Turn()) {
+			return col
+		}
+	}
+
+	if e.settings.Blunder > 0 && e.rng.Float64() < e.settings.Blunder {
+		return legal[e.rng.Intn(len(legal))]
+	}
+
+	pos := b.Position()
+	e.deadline = time.Now().Add(min(budget, e.settings.TimeBudget))
+	e.nodes = 0
+	e.aborted = false
+
+	bestMove := legal[0]
+	for depth := 1; depth <= e.settings.MaxDepth && depth <= game.MaxMoves-pos.Ply(); depth++ {
+		move, score := e.searchRoot(&pos, depth, bestMove)
+		if e.aborted {
+			break
+		}
+		bestMove = move
+		if score >= winScore-game.MaxMoves || score <= -(winScore-game.MaxMoves) {
+			break
+		}
+	}
+
+	return bestMove
+}
+
+///////////////////////////////////////
+// searchRoot searches every root move to depth, trying the previous best first.
+///////////////////////////////////////
+
+func (e *Engine) searchRoot(pos *game.Position, depth int, previousBest int) (int, int) {
+	alpha, beta := -infinity, infinity
+	bestMove, bestScore := -1, -infinity
+
+	for _, col := range orderedMoves(pos, previousBest) {
+		var score int
+		if pos.IsWinningMove(col) {
+			score = winScore - (pos.Ply() + 1)
+		} else {
+			pos.Play(col)
+			score = -e.negamax(pos, depth-1, -beta, -alpha)
+			pos.Undo(col)
+		}
+
+		if e.aborted {
+			return bestMove, bestScore
+		}
+		if score > bestScore {
+			bestMove, bestScore = col, score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	return bestMove, bestScore
+}
+
+func (e *Engine) negamax(pos *game.Position, depth, alpha, beta int) int {
+	e.nodes++
+	if e.nodes&1023 == 0 && time.Now().After(e.deadline) {
+		e.aborted = true
+	}
+	if e.aborted {
+		return 0
+	}
+	if pos.IsFull() {
+		return 0
+	}
+	if depth == 0 {
+		return evaluate(pos)
+	}
+
+	key := pos.Key()
+	alphaOrig := alpha
+	ttMove := -1
+	if entry, ok := e.table.probe(key); ok {
+		ttMove = int(entry.move)
+		if int(entry.depth) >= depth {
+			score := int(entry.score)
+			switch entry.flag {
+			case flagExact:
+				return score
+			case flagLower:
+				alpha = max(alpha, score)
+			case flagUpper:
+				beta = min(beta, score)
+			}
+			if alpha >= beta {
+				return score
+			}
+		}
+	}
+
+	bestScore, bestMove := -infinity, -1
+	for _, col := range orderedMoves(pos, ttMove) {
+		var score int
+		if pos.IsWinningMove(col) {
+			score = winScore - (pos.Ply() + 1)
+		} else {
+			pos.Play(col)
+			score = -e.negamax(pos, depth-1, -beta, -alpha)
+			pos.Undo(col)
+		}
+
+		if e.aborted {
+			return 0
+		}
+		if score > bestScore {
+			bestScore, bestMove = score, col
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+
+	flag := flagExact
+	if bestScore <= alphaOrig {
+		flag = flagUpper
+	} else if bestScore >= beta {
+		flag = flagLower
+	}
+	e.table.store(key, depth, bestScore, flag, bestMove)
+
+	return bestScore
+}
+
+///////////////////////////////////////
+// orderedMoves returns the legal columns from the center out, with first
+// (typically the transposition table move) moved to the front.
+///////////////////////////////////////
+
+func orderedMoves(pos *game.Position, first int) []int {
+	moves := make([]int, 0, game.Columns)
+	if first >= 0 && pos.CanPlay(first) {
+		moves = append(moves, first)
+	}
+	for _, col := range columnOrder {
+		if col != first && pos.CanPlay(col) {
+			moves = append(moves, col)
+		}
+	}
+	return moves
+}
+
+///////////////////////////////////////
+// evaluate scores a quiet position from the side to move's point of view by
+// counting discs in every window of four that the opponent has not blocked.
+///////////////////////////////////////
+
+var (
+	windowWeights = [game.ConnectLength + 1]int{0, 1, 4, 16, 0}
+	centerMask    = game.ColumnMask(game.Columns / 2)
+)
+
+func evaluate(pos *game.Position) int {
+	mine := pos.Discs(pos.Turn())
+	theirs := pos.Discs(pos.Turn().Other())
+
+	score := 3 * (bits.OnesCount64(mine&centerMask) - bits.OnesCount64(theirs&centerMask))
+
+	for _, window := range game.Windows {
+		m, t := bits.OnesCount64(mine&window), bits.OnesCount64(theirs&window)
+		if t == 0 {
+			score += windowWeights[m]
+		} else if m == 0 {
+			score -= windowWeights[t]
+		}
+	}
+
+	return score
+}
+
+///////////////////////////////////////
+// TRANSPOSITION TABLE
+// Fixed size, always-replace, keyed by game.Position.Key.
+///////////////////////////////////////
+
+const (
+	flagExact uint8 = iota + 1
+	flagLower
+	flagUpper
+)
+
+type tableEntry struct {
+	key   uint64
+	score int32
+	depth int8
+	flag  uint8
+	move  int8
+}
+
+type transpositionTable struct {
+	entries []tableEntry
+	shift   uint
+}
+
+func newTranspositionTable(size uint) *transpositionTable {
+	return &transpositionTable{
+		entries: make([]tableEntry, 1<<size),
+		shift:   64 - size,
+	}
+}
+
+// index spreads the structured position keys with a multiplicative hash.
+func (t *transpositionTable) index(key uint64) uint64 {
+	return (key * 0x9E3779B97F4A7C15) >> t.shift
+}
+
+func (t *transpositionTable) probe(key uint64) (tableEntry, bool) {
+	entry := t.entries[t.index(key)]
+	return entry, entry.flag != 0 && entry.key == key
+}
+
+func (t *transpositionTable) store(key uint64, depth, score int, flag uint8, move int) {
+	t.entries[t.index(key)] = tableEntry{
+		key:   key,
+		score: int32(score),
+		depth: int8(depth),
+		flag:  flag,
+		move:  int8(move),
+	}
+}
@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"backend/game"
+	"reflect"
+	"testing"
+)
+
+// A full board with no four in a row.
+var drawMoves = []int{4, 3, 6, 0, 1, 4, 5, 5, 1, 1, 5, 0, 1, 6, 0, 1, 5, 5, 1, 0, 4, 6, 3, 2, 6, 6, 0, 4, 6, 5, 2, 0, 4, 2, 4, 2, 2, 2, 3, 3, 3, 3}
+
+func board(t *testing.T, moves []int) *game.Board {
+	t.Helper()
+	b, err := game.FromMoves(moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBestMoveTakesAnImmediateWin(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves []int
+		want  int
+	}{
+		{"vertical", []int{0, 1, 0, 1, 0, 1}, 0},
+		{"horizontal", []int{0, 0, 1, 1, 2, 6}, 3},
+	}
+	// Every level, since even a blundering bot never misses a win.
+	for _, difficulty := range Difficulties {
+		for _, tt := range tests {
+			if got := NewEngine(difficulty).BestMove(board(t, tt.moves)); got != tt.want {
+				t.Errorf("%s, %s win: BestMove = %d, want %d", difficulty, tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestBestMoveBlocksAnImmediateWin(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves []int
+		want  int
+	}{
+		{"vertical", []int{0, 1, 2, 1, 6, 1}, 1},
+		{"horizontal", []int{6, 0, 6, 1, 5, 2}, 3},
+	}
+	// Only the levels that never blunder.
+	for _, difficulty := range []Difficulty{Hard, Perfect} {
+		for _, tt := range tests {
+			if got := NewEngine(difficulty).BestMove(board(t, tt.moves)); got != tt.want {
+				t.Errorf("%s, %s threat: BestMove = %d, want %d", difficulty, tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestBestMoveLeavesTheBoardUnchanged(t *testing.T) {
+	for _, difficulty := range Difficulties {
+		b := board(t, benchMoves)
+		grid := b.Grid()
+		NewEngine(difficulty).BestMove(b)
+
+		if !reflect.DeepEqual(b.Moves(), benchMoves) || !reflect.DeepEqual(b.Grid(), grid) {
+			t.Errorf("%s: BestMove changed the board", difficulty)
+		}
+	}
+}
+
+func TestBestMoveOnAFinishedBoard(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves []int
+	}{
+		{"full", drawMoves},
+		{"won", []int{0, 1, 0, 1, 0, 1, 0}},
+	}
+	for _, tt := range tests {
+		if got := NewEngine(Hard).BestMove(board(t, tt.moves)); got != -1 {
+			t.Errorf("%s board: BestMove = %d, want -1", tt.name, got)
+		}
+	}
+}
+
+func TestParseDifficulty(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Difficulty
+		ok   bool
+	}{
+		{"", DefaultDifficulty, true},
+		{"easy", Easy, true},
+		{"perfect", Perfect, true},
+		{"insane", "", false},
+		{"Hard", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseDifficulty(tt.in)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("ParseDifficulty(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// A mid-game position with no winner yet.
+var benchMoves = []int{3, 3, 2, 4, 4, 2, 5, 1, 1, 3, 2, 5, 6, 0}
+
+var colSink int
+
+func BenchmarkBestMove(b *testing.B) {
+	position, err := game.FromMoves(benchMoves)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, difficulty := range []Difficulty{Easy, Medium, Hard} {
+		b.Run(string(difficulty), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// A fresh engine per move so the transposition table starts cold.
+				b.StopTimer()
+				engine := NewEngine(difficulty)
+				b.StartTimer()
+				colSink = engine.BestMove(position)
+			}
+		})
+	}
+}
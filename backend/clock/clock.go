@@ -0,0 +1,292 @@
+package clock
+
+import (
+	"backend/game"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////
+// TIME CONTROLS
+///////////////////////////////////////
+
+type Mode string
+
+const (
+	Fischer        Mode = "fischer"        // a bank of time per player, plus an increment after every move
+	PerMove        Mode = "per_move"       // a fixed number of seconds for every move
+	Correspondence Mode = "correspondence" // a fixed number of days for every move
+)
+
+var Modes = []Mode{Fischer, PerMove, Correspondence}
+
+var ErrFlagFall = errors.New("time has run out")
+
+// TimeControl is comparable so matchmaking can pair players who chose the same one.
+type TimeControl struct {
+	Mode      Mode
+	Initial   time.Duration // fischer only
+	Increment time.Duration // fischer only
+	PerMove   time.Duration // per_move and correspondence
+}
+
+var DefaultTimeControl = TimeControl{Mode: Fischer, Initial: 5 * time.Minute, Increment: 5 * time.Second}
+
+// Bounds on what a client may ask for.
+const (
+	MinInitial        = 30 * time.Second
+	MaxInitial        = 3 * time.Hour
+	MaxIncrement      = time.Minute
+	MinPerMove        = 5 * time.Second
+	MaxPerMove        = 10 * time.Minute
+	Day               = 24 * time.Hour
+	MaxCorrespondence = 14 * Day
+)
+
+///////////////////////////////////////
+// Spec is a time control as clients send and receive it:
+//
+//   { "mode": "fischer", "initial": 300, "increment": 5 }   seconds
+//   { "mode": "per_move", "seconds": 30 }
+//   { "mode": "correspondence", "days": 3 }
+///////////////////////////////////////
+
+type Spec struct {
+	Mode      Mode     `json:"mode"`
+	Initial   *float64 `json:"initial,omitempty"`
+	Increment *float64 `json:"increment,omitempty"`
+	Seconds   *float64 `json:"seconds,omitempty"`
+	Days      *float64 `json:"days,omitempty"`
+}
+
+// ParseTimeControl validates a Spec. A nil spec selects DefaultTimeControl.
+func ParseTimeControl(spec *Spec) (TimeControl, error) {
+	if spec == nil {
+		return DefaultTimeControl, nil
+	}
+
+	switch spec.Mode {
+	case Fischer:
+		initial, err := durationField("initial", spec.Initial, time.Second, MinInitial, MaxInitial)
+		if err != nil {
+			return TimeControl{}, err
+		}
+		increment := time.Duration(0)
+		if spec.Increment != nil {
+			increment, err = durationField("increment", spec.Increment, time.Second, 0, MaxIncrement)
+			if err != nil {
+				return TimeControl{}, err
+			}
+		}
+		return TimeControl{Mode: Fischer, Initial: initial, Increment: increment}, nil
+	case PerMove:
+		perMove, err := durationField("seconds", spec.Seconds, time.Second, MinPerMove, MaxPerMove)
+		if err != nil {
+			return TimeControl{}, err
+		}
+		return TimeControl{Mode: PerMove, PerMove: perMove}, nil
+	case Correspondence:
+		perMove, err := durationField("days", spec.Days, Day, Day, MaxCorrespondence)
+		if err != nil {
+			return TimeControl{}, err
+		}
+		return TimeControl{Mode: Correspondence, PerMove: perMove}, nil
+	}
+
+	return TimeControl{}, fmt.Errorf("invalid time control mode %q, expected fischer, per_move or correspondence", spec.Mode)
+}
+
+func durationField(name string, value *float64, unit, min, max time.Duration) (time.Duration, error) {
+	if value == nil {
+		return 0, fmt.Errorf("missing time control %s", name)
+	}
+	d := time.Duration(*value * float64(unit))
+	if d < min || d > max {
+		return 0, fmt.Errorf("invalid time control %s, expected %v to %v", name, min/unit, max/unit)
+	}
+	return d, nil
+}
+
+// Budget is the time a player has for their first move.
+func (tc TimeControl) Budget() time.Duration {
+	if tc.Mode == Fischer {
+		return tc.Initial
+	}
+	return tc.PerMove
+}
+
+// MaxDuration is the longest a game of plies moves can last before one
+// clock must run out.
+func (tc TimeControl) MaxDuration(plies int) time.Duration {
+	if tc.Mode == Fischer {
+		return 2*tc.Initial + time.Duration(plies)*tc.Increment
+	}
+	return time.Duration(plies) * tc.PerMove
+}
+
+// Spec returns the time control in its wire format.
+func (tc TimeControl) Spec() Spec {
+	spec := Spec{Mode: tc.Mode}
+	switch tc.Mode {
+	case Fischer:
+		initial, increment := tc.Initial.Seconds(), tc.Increment.Seconds()
+		spec.Initial, spec.Increment = &initial, &increment
+	case PerMove:
+		seconds := tc.PerMove.Seconds()
+		spec.Seconds = &seconds
+	case Correspondence:
+		days := tc.PerMove.Hours() / 24
+		spec.Days = &days
+	}
+	return spec
+}
+
+func (tc TimeControl) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tc.Spec())
+}
+
+///////////////////////////////////////
+// CLOCK
+// Tracks the remaining time of both colors. Only the clock of the player to
+// move runs; when it reaches zero onFlag is called once with that player.
+// A Clock is safe for concurrent use.
+///////////////////////////////////////
+
+type Clock struct {
+	Control TimeControl
+
+	mu          sync.Mutex
+	remaining   [2]time.Duration
+	running     game.Player
+	turnStarted time.Time
+	timer       *time.Timer
+	onFlag      func(game.Player)
+}
+
+func New(control TimeControl, onFlag func(game.Player)) *Clock {
+	budget := control.Budget()
+	return &Clock{
+		Control:   control,
+		remaining: [2]time.Duration{budget, budget},
+		onFlag:    onFlag,
+	}
+}
+
+// Start runs the clock of player, normally Red at the start of the game.
+func (c *Clock) Start(player game.Player, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.startLocked(player, now)
+}
+
+///////////////////////////////////////
+// Press ends the running player's turn and starts the opponent's clock.
+// Fischer adds the increment; the other modes refill the per-move time.
+// It returns ErrFlagFall, and leaves the clock alone, if the running player
+// was already out of time.
+///////////////////////////////////////
+
+func (c *Clock) Press(now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mover := c.running
+	if mover == game.NoPlayer {
+		return nil
+	}
+	left := c.remainingLocked(mover, now)
+	if left <= 0 {
+		return ErrFlagFall
+	}
+
+	c.stopLocked(now)
+	if c.Control.Mode == Fischer {
+		c.remaining[mover-1] += c.Control.Increment
+	} else {
+		c.remaining[mover-1] = c.Control.PerMove
+	}
+	c.startLocked(mover.Other(), now)
+	return nil
+}
+
+///////////////////////////////////////
+// Restart stops the running clock without any increment and starts the
+// clock of player instead, as after a takeback. In the per-move modes the
+// player gets a full move's time again.
+///////////////////////////////////////
+
+func (c *Clock) Restart(player game.Player, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopLocked(now)
+	if c.Control.Mode != Fischer {
+		c.remaining[player-1] = c.Control.PerMove
+	}
+	c.startLocked(player, now)
+}
+
+// Flagged reports whether the running player has run out of time.
+func (c *Clock) Flagged(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running != game.NoPlayer && c.remainingLocked(c.running, now) <= 0
+}
+
+// Stop freezes both clocks, for example when the game ends.
+func (c *Clock) Stop(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopLocked(now)
+}
+
+func (c *Clock) Remaining(player game.Player, now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return max(c.remainingLocked(player, now), 0)
+}
+
+func (c *Clock) Running() game.Player {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+func (c *Clock) startLocked(player game.Player, now time.Time) {
+	c.running = player
+	c.turnStarted = now
+	left := c.remaining[player-1]
+	c.timer = time.AfterFunc(left, func() {
+		c.mu.Lock()
+		flagged := c.running == player && c.remainingLocked(player, time.Now()) <= 0
+		if flagged {
+			c.stopLocked(time.Now())
+		}
+		c.mu.Unlock()
+		if flagged && c.onFlag != nil {
+			c.onFlag(player)
+		}
+	})
+}
+
+func (c *Clock) stopLocked(now time.Time) {
+	if c.running == game.NoPlayer {
+		return
+	}
+	c.remaining[c.running-1] = c.remainingLocked(c.running, now)
+	c.running = game.NoPlayer
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+func (c *Clock) remainingLocked(player game.Player, now time.Time) time.Duration {
+	left := c.remaining[player-1]
+	if player == c.running {
+		left -= now.Sub(c.turnStarted)
+	}
+	return left
+}
@@ -0,0 +1,171 @@
+package clock
+
+import (
+	"backend/game"
+	"testing"
+	"time"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestParseTimeControl(t *testing.T) {
+	valid := []struct {
+		spec *Spec
+		want TimeControl
+	}{
+		{nil, DefaultTimeControl},
+		{&Spec{Mode: Fischer, Initial: float(180), Increment: float(2)}, TimeControl{Mode: Fischer, Initial: 3 * time.Minute, Increment: 2 * time.Second}},
+		{&Spec{Mode: Fischer, Initial: float(60)}, TimeControl{Mode: Fischer, Initial: time.Minute}},
+		{&Spec{Mode: PerMove, Seconds: float(30)}, TimeControl{Mode: PerMove, PerMove: 30 * time.Second}},
+		{&Spec{Mode: Correspondence, Days: float(3)}, TimeControl{Mode: Correspondence, PerMove: 3 * Day}},
+	}
+	for _, tt := range valid {
+		got, err := ParseTimeControl(tt.spec)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTimeControl(%+v) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+
+	invalid := []*Spec{
+		{Mode: "blitz"},
+		{Mode: Fischer},
+		{Mode: Fischer, Initial: float(10)},
+		{Mode: Fischer, Initial: float(60), Increment: float(120)},
+		{Mode: PerMove, Seconds: float(1)},
+		{Mode: PerMove, Seconds: float(3600)},
+		{Mode: Correspondence, Days: float(0.5)},
+		{Mode: Correspondence, Days: float(15)},
+	}
+	for _, spec := range invalid {
+		if tc, err := ParseTimeControl(spec); err == nil {
+			t.Errorf("ParseTimeControl(%+v) = %+v, want an error", spec, tc)
+		}
+	}
+}
+
+func TestSpecRoundTrips(t *testing.T) {
+	for _, tc := range []TimeControl{
+		DefaultTimeControl,
+		{Mode: PerMove, PerMove: 45 * time.Second},
+		{Mode: Correspondence, PerMove: 14 * Day},
+	} {
+		spec := tc.Spec()
+		got, err := ParseTimeControl(&spec)
+		if err != nil || got != tc {
+			t.Errorf("%+v came back as %+v, %v", tc, got, err)
+		}
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	fischer := TimeControl{Mode: Fischer, Initial: time.Minute, Increment: time.Second}
+	if got := fischer.MaxDuration(10); got != 2*time.Minute+10*time.Second {
+		t.Errorf("fischer MaxDuration(10) = %v", got)
+	}
+	correspondence := TimeControl{Mode: Correspondence, PerMove: 14 * Day}
+	if got := correspondence.MaxDuration(game.MaxMoves); got != game.MaxMoves*14*Day {
+		t.Errorf("correspondence MaxDuration = %v", got)
+	}
+}
+
+func TestFischerPressAddsIncrement(t *testing.T) {
+	start := time.Now()
+	c := New(TimeControl{Mode: Fischer, Initial: time.Minute, Increment: 5 * time.Second}, nil)
+	defer c.Stop(start)
+	c.Start(game.Red, start)
+
+	if err := c.Press(start.Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Remaining(game.Red, start.Add(20*time.Second)); got != 55*time.Second {
+		t.Errorf("red has %v after a 10s move and a 5s increment, want 55s", got)
+	}
+	if got := c.Remaining(game.Blue, start.Add(20*time.Second)); got != 50*time.Second {
+		t.Errorf("blue has %v after thinking 10s, want 50s", got)
+	}
+	if c.Running() != game.Blue {
+		t.Errorf("running = %v, want blue", c.Running())
+	}
+}
+
+func TestPerMoveRefillsAfterEveryMove(t *testing.T) {
+	start := time.Now()
+	c := New(TimeControl{Mode: PerMove, PerMove: 30 * time.Second}, nil)
+	defer c.Stop(start)
+	c.Start(game.Red, start)
+
+	c.Press(start.Add(20 * time.Second))
+	if got := c.Remaining(game.Red, start.Add(20*time.Second)); got != 30*time.Second {
+		t.Errorf("red has %v after moving, want a full 30s", got)
+	}
+
+	c.Restart(game.Red, start.Add(25*time.Second))
+	if c.Running() != game.Red || c.Remaining(game.Red, start.Add(25*time.Second)) != 30*time.Second {
+		t.Errorf("Restart did not give red a full move")
+	}
+}
+
+func TestPressAfterTimeRunsOut(t *testing.T) {
+	start := time.Now()
+	c := New(TimeControl{Mode: PerMove, PerMove: 30 * time.Second}, nil)
+	defer c.Stop(start)
+	c.Start(game.Red, start)
+
+	late := start.Add(31 * time.Second)
+	if !c.Flagged(late) {
+		t.Errorf("Flagged = false after the budget ran out")
+	}
+	if err := c.Press(late); err != ErrFlagFall {
+		t.Errorf("Press error = %v, want ErrFlagFall", err)
+	}
+	if c.Running() != game.Red || c.Remaining(game.Red, late) != 0 {
+		t.Errorf("a late Press changed the clock")
+	}
+}
+
+func TestFlagFallCallsOnFlagOnce(t *testing.T) {
+	flags := make(chan game.Player, 2)
+	c := New(TimeControl{Mode: Fischer, Initial: 20 * time.Millisecond}, func(player game.Player) {
+		flags <- player
+	})
+	c.Start(game.Red, time.Now())
+
+	select {
+	case player := <-flags:
+		if player != game.Red {
+			t.Errorf("flag fell for %v, want red", player)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("onFlag was not called")
+	}
+	if c.Running() != game.NoPlayer {
+		t.Errorf("clock still running for %v after the flag fell", c.Running())
+	}
+
+	select {
+	case player := <-flags:
+		t.Errorf("onFlag called again for %v", player)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStopPreventsFlagFall(t *testing.T) {
+	flagged := make(chan game.Player, 1)
+	c := New(TimeControl{Mode: Fischer, Initial: 100 * time.Millisecond}, func(player game.Player) {
+		flagged <- player
+	})
+	start := time.Now()
+	c.Start(game.Red, start)
+	c.Stop(start.Add(5 * time.Millisecond))
+
+	select {
+	case player := <-flagged:
+		t.Errorf("flag fell for %v on a stopped clock", player)
+	case <-time.After(150 * time.Millisecond):
+	}
+	if got := c.Remaining(game.Red, time.Now()); got != 95*time.Millisecond {
+		t.Errorf("red has %v on the stopped clock, want 95ms", got)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"backend/bot"
+	"backend/clock"
+	"backend/game"
+	"backend/managers/room"
+	"backend/managers/types"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////
+// protogen writes the TypeScript definitions of the websocket protocol
+// from the message catalogue in managers/types, so the frontend and the
+// server cannot drift apart.
+//
+//   go generate ./managers/types
+//   go run ./cmd/protogen -out ../frontend/src/types/ProtocolTypes.ts
+///////////////////////////////////////
+
+// Go types written as a named TypeScript union or interface instead of
+// their underlying kind.
+var namedTypes = map[reflect.Type]string{
+	reflect.TypeOf(game.NoPlayer):            "PlayerColor",
+	reflect.TypeOf(bot.DefaultDifficulty):    "BotDifficulty",
+	reflect.TypeOf(clock.Fischer):            "TimeControlMode",
+	reflect.TypeOf(clock.DefaultTimeControl): "TimeControl",
+	reflect.TypeOf(clock.Spec{}):             "TimeControl",
+	reflect.TypeOf(time.Time{}):              "string",
+	reflect.TypeOf(json.RawMessage{}):        "unknown",
+	reflect.TypeOf(types.ErrCodeInternal):    "ErrorCode",
+}
+
+// String fields that only take a known set of values.
+var fieldTypes = map[string]string{
+	"NewGameRequest.Difficulty":     "BotDifficulty",
+	"NewGameRequest.Opponent":       `"any" | "human"`,
+	"GameUpdateRequest.Action":      "GameAction",
+	"GameUpdateRequest.PlayerColor": "PlayerColor",
+	"EmoteRequest.Emote":            "Emote",
+	"ChatLine.Emote":                "Emote",
+	"ChatLine.Kind":                 `"chat" | "emote"`,
+	"GameStateData.OpponentType":    `"human" | "bot"`,
+	"SpectateData.OpponentType":     `"human" | "bot"`,
+	"GameMove.Color":                "PlayerColor",
+}
+
+func main() {
+	out := flag.String("out", "", "file to write, standard output if empty")
+	flag.Parse()
+
+	g := &generator{seen: make(map[reflect.Type]bool)}
+	g.header()
+	for _, spec := range types.ClientMessages {
+		g.collect(reflect.TypeOf(spec.Data))
+	}
+	for _, spec := range types.ServerMessages {
+		g.collect(reflect.TypeOf(spec.Data))
+	}
+	g.interfaces()
+	g.union("ClientMessage", types.ClientMessages, "version?: number; request_id?: string; username?: string")
+	g.union("ServerMessage", types.ServerMessages, "version: number")
+
+	if *out == "" {
+		os.Stdout.Write(g.buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(*out, g.buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "protogen:", err)
+		os.Exit(1)
+	}
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	seen    map[reflect.Type]bool
+	structs []reflect.Type // in the order they were first reached
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) header() {
+	g.printf("// Code generated by cmd/protogen from backend/managers/types. DO NOT EDIT.\n\n")
+	g.printf("export const PROTOCOL_VERSION = %d;\n", types.ProtocolVersion)
+	g.printf("export const MIN_PROTOCOL_VERSION = %d;\n\n", types.MinProtocolVersion)
+
+	g.printf("export type PlayerColor = %s;\n", quoteAll([]string{game.Red.String(), game.Blue.String(), game.NoPlayer.String()}))
+	difficulties := make([]string, len(bot.Difficulties))
+	for i, difficulty := range bot.Difficulties {
+		difficulties[i] = string(difficulty)
+	}
+	g.printf("export type BotDifficulty = %s;\n", quoteAll(difficulties))
+	modes := make([]string, len(clock.Modes))
+	for i, mode := range clock.Modes {
+		modes[i] = string(mode)
+	}
+	g.printf("export type TimeControlMode = %s;\n", quoteAll(modes))
+	g.printf("export type GameAction = %s;\n", quoteAll(types.Actions))
+	emotes := make([]string, 0, len(room.Emotes))
+	for emote := range room.Emotes {
+		emotes = append(emotes, emote)
+	}
+	sort.Strings(emotes)
+	g.printf("export type Emote = %s;\n", quoteAll(emotes))
+	codes := make([]string, len(types.ErrorCodes))
+	for i, code := range types.ErrorCodes {
+		codes[i] = string(code)
+	}
+	g.printf("export type ErrorCode =\n    | %s;\n\n", strings.Join(strings.Split(quoteAll(codes), " | "), "\n    | "))
+
+	g.printf("export type TimeControl =\n")
+	g.printf("    | { mode: %q; initial: number; increment: number }\n", clock.Fischer)
+	g.printf("    | { mode: %q; seconds: number }\n", clock.PerMove)
+	g.printf("    | { mode: %q; days: number };\n\n", clock.Correspondence)
+}
+
+// collect records every struct reachable from t that needs an interface.
+func (g *generator) collect(t reflect.Type) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if _, named := namedTypes[t]; named || t.Kind() != reflect.Struct || g.seen[t] {
+		return
+	}
+	g.seen[t] = true
+	g.structs = append(g.structs, t)
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.IsExported() {
+			g.collect(field.Type)
+		}
+	}
+}
+
+func (g *generator) interfaces() {
+	for _, t := range g.structs {
+		if t.NumField() == 0 {
+			g.printf("export type %s = Record<string, never>;\n\n", t.Name())
+			continue
+		}
+		g.printf("export interface %s {\n", t.Name())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitEmpty, ok := jsonName(field)
+			if !ok {
+				continue
+			}
+			optional := ""
+			if omitEmpty || field.Type.Kind() == reflect.Pointer {
+				optional = "?"
+			}
+			tsType, overridden := fieldTypes[t.Name()+"."+field.Name]
+			if !overridden {
+				tsType = typeName(field.Type)
+			}
+			g.printf("    %s%s: %s;\n", name, optional, tsType)
+		}
+		g.printf("}\n\n")
+	}
+}
+
+// union writes a discriminated union of every message in specs.
+func (g *generator) union(name string, specs []types.MessageSpec, envelope string) {
+	g.printf("export type %s =\n", name)
+	for _, spec := range specs {
+		g.printf("    | { type: %q; %s; data: %s }\n", spec.Type, envelope, typeName(reflect.TypeOf(spec.Data)))
+	}
+	g.buf.Truncate(g.buf.Len() - 1)
+	g.printf(";\n\n")
+	g.printf("export type %sOf<T extends %s[\"type\"]> = Extract<%s, { type: T }>;\n", name, name, name)
+	if name == "ClientMessage" {
+		g.printf("\n")
+	}
+}
+
+func typeName(t reflect.Type) string {
+	if name, named := namedTypes[t]; named {
+		return name
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeName(t.Elem())
+	case reflect.Slice, reflect.Array:
+		elem := typeName(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + typeName(t.Elem()) + ">"
+	case reflect.Struct:
+		return t.Name()
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return "unknown"
+}
+
+func jsonName(field reflect.StructField) (name string, omitEmpty bool, ok bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(options, "omitempty"), true
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return strings.Join(quoted, " | ")
+}
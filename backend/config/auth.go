@@ -0,0 +1,32 @@
+package config
+
+import (
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type AuthConfig struct {
+	Secret     []byte        // signs access tokens
+	AccessTTL  time.Duration // how long an access token is valid
+	RefreshTTL time.Duration // how long a refresh token is valid
+}
+
+func LoadAuthConfig() (*AuthConfig, error) {
+	godotenv.Load()
+
+	secret, err := secretEnv("JWT_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	accessTTL, err := secondsEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	refreshTTL, err := secondsEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthConfig{Secret: secret, AccessTTL: accessTTL, RefreshTTL: refreshTTL}, nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// positiveEnv reads a positive number from the environment variable name,
+// or returns fallback if it is not set.
+func positiveEnv(name string, fallback float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+// secondsEnv reads a positive number of seconds from the environment
+// variable name, or returns fallback if it is not set.
+func secondsEnv(name string, fallback time.Duration) (time.Duration, error) {
+	seconds, err := positiveEnv(name, fallback.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// secretEnv reads a signing secret from the environment variable name. It
+// may be empty, but not short.
+func secretEnv(name string) ([]byte, error) {
+	secret := os.Getenv(name)
+	if secret != "" && len(secret) < 32 {
+		return nil, fmt.Errorf("%s must be at least 32 characters", name)
+	}
+	return []byte(secret), nil
+}
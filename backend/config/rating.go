@@ -0,0 +1,20 @@
+package config
+
+import (
+	"github.com/joho/godotenv"
+)
+
+type RatingConfig struct {
+	KFactor float64
+}
+
+func LoadRatingConfig() (*RatingConfig, error) {
+	godotenv.Load()
+
+	kFactor, err := positiveEnv("RATING_K_FACTOR", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RatingConfig{KFactor: kFactor}, nil
+}
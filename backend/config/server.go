@@ -2,16 +2,24 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type ServerConfig struct {
-	Port string
+	Port             string
+	HeartbeatTimeout time.Duration // how long a silent websocket is kept before it counts as disconnected
 }
 
 func LoadServerConfig() (*ServerConfig, error) {
 	godotenv.Load()
 	port := os.Getenv("PORT")
-	return &ServerConfig{Port: port}, nil
+
+	heartbeatTimeout, err := secondsEnv("HEARTBEAT_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServerConfig{Port: port, HeartbeatTimeout: heartbeatTimeout}, nil
 }
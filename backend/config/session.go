@@ -0,0 +1,27 @@
+package config
+
+import (
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type SessionConfig struct {
+	Secret []byte        // signs session tokens
+	TTL    time.Duration // how long a session token is valid
+}
+
+func LoadSessionConfig() (*SessionConfig, error) {
+	godotenv.Load()
+
+	secret, err := secretEnv("SESSION_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := secondsEnv("SESSION_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionConfig{Secret: secret, TTL: ttl}, nil
+}
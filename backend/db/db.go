@@ -2,15 +2,19 @@ package db
 
 import (
 	"backend/config"
+	"backend/rating"
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type DB struct {
 	Pool *pgxpool.Pool
+	Elo  rating.Elo
 }
 
 type Player struct {
@@ -24,12 +28,94 @@ type Player struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// User is an account that can sign in as the player with the same
+// username. Guests have no password and only keep their account through
+// refresh tokens.
+type User struct {
+	ID           int64     `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	Guest        bool      `json:"guest"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+var (
+	ErrUsernameTaken       = errors.New("username is already taken")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid, expired or revoked")
+)
+
+// Game results, from the point of view of the seats.
+const (
+	ResultRedWin  = "red_win"
+	ResultBlueWin = "blue_win"
+	ResultDraw    = "draw"
+	ResultNone    = "none"
+)
+
+// Reasons a game ended.
+const (
+	TerminationConnectFour       = "connect_four"
+	TerminationDraw              = "draw"
+	TerminationDisconnectTimeout = "disconnect_timeout"
+	TerminationAbandonment       = "abandonment"
+	TerminationTimeout           = "timeout"
+	TerminationResignation       = "resignation"
+	TerminationDrawAgreement     = "draw_agreement"
+	TerminationAborted           = "aborted"
+)
+
+type Game struct {
+	ID            int64     `json:"id"`
+	RoomID        string    `json:"room_id"`
+	RedPlayer     string    `json:"red_player"`
+	BluePlayer    string    `json:"blue_player"`
+	OpponentType  string    `json:"opponent_type"`
+	BotDifficulty string    `json:"bot_difficulty,omitempty"`
+	Winner        string    `json:"winner,omitempty"`
+	Result        string    `json:"result"`
+	Termination   string    `json:"termination"`
+	StartedAt     time.Time `json:"started_at"`
+	EndedAt       time.Time `json:"ended_at"`
+}
+
+// RatingChange is one player's rating before and after a game.
+type RatingChange struct {
+	Username string `json:"username"`
+	Before   int    `json:"before"`
+	After    int    `json:"after"`
+	Delta    int    `json:"delta"`
+}
+
+type RatingHistoryEntry struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	GameID    *int64    `json:"game_id"`
+	OldRating int       `json:"old_rating"`
+	NewRating int       `json:"new_rating"`
+	Delta     int       `json:"delta"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type GameMove struct {
+	Ply      int       `json:"ply"`
+	Column   int       `json:"column"`
+	Player   string    `json:"player"`
+	Color    string    `json:"color"`
+	PlayedAt time.Time `json:"played_at"`
+}
+
 func NewDB() (*DB, error) {
 	dbConfig, err := config.LoadDBConfig()
 	if err != nil {
 		return nil, err
 	}
 
+	ratingConfig, err := config.LoadRatingConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	connString := dbConfig.DatabaseURL
 	pool, err := pgxpool.New(context.Background(), connString)
 	if err != nil {
@@ -40,7 +126,7 @@ func NewDB() (*DB, error) {
 		return nil, fmt.Errorf("unable to ping database: %v", err)
 	}
 
-	db := &DB{Pool: pool}
+	db := &DB{Pool: pool, Elo: rating.NewElo(ratingConfig.KFactor)}
 
 	if err := db.initTables(); err != nil {
 		return nil, err
@@ -67,6 +153,63 @@ func (db *DB) initTables() error {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS games (
+		id BIGSERIAL PRIMARY KEY,
+		room_id VARCHAR(64) UNIQUE NOT NULL,
+		red_player VARCHAR(255) NOT NULL,
+		blue_player VARCHAR(255) NOT NULL,
+		opponent_type VARCHAR(16) NOT NULL,
+		bot_difficulty VARCHAR(16),
+		winner VARCHAR(255),
+		result VARCHAR(16) NOT NULL,
+		termination VARCHAR(32) NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		ended_at TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS games_red_player_idx ON games (red_player, id DESC);
+	CREATE INDEX IF NOT EXISTS games_blue_player_idx ON games (blue_player, id DESC);
+
+	CREATE TABLE IF NOT EXISTS game_moves (
+		game_id BIGINT NOT NULL REFERENCES games(id) ON DELETE CASCADE,
+		ply INTEGER NOT NULL,
+		column_index INTEGER NOT NULL,
+		player VARCHAR(255) NOT NULL,
+		color VARCHAR(8) NOT NULL,
+		played_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (game_id, ply)
+	);
+
+	CREATE TABLE IF NOT EXISTS rating_history (
+		id BIGSERIAL PRIMARY KEY,
+		username VARCHAR(255) NOT NULL,
+		game_id BIGINT REFERENCES games(id) ON DELETE SET NULL,
+		old_rating INTEGER NOT NULL,
+		new_rating INTEGER NOT NULL,
+		delta INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS rating_history_username_idx ON rating_history (username, created_at);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id BIGSERIAL PRIMARY KEY,
+		username VARCHAR(255) UNIQUE NOT NULL REFERENCES players(username),
+		password_hash VARCHAR(255),
+		is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token_hash CHAR(64) PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
 	`
 
 	_, err := db.Pool.Exec(context.Background(), query)
@@ -127,7 +270,7 @@ func (db *DB) GetPlayerByUsername(username string) (*Player, error) {
 		&p.Rating, &p.CreatedAt, &p.UpdatedAt,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("player not found: %v", err)
+		return nil, fmt.Errorf("failed to get player: %w", err)
 	}
 
 	return &p, nil
@@ -153,65 +296,473 @@ func (db *DB) CreateOrUpdatePlayer(username string) (*Player, error) {
 	return &p, nil
 }
 
-func (db *DB) UpdateGameResult(winner, loser string) error {
+// UpdateGameResult records a win and a loss and moves both ratings by Elo.
+// It returns the winner's rating change followed by the loser's. Both
+// changes are written to rating_history against gameID, or with no game
+// when gameID is 0.
+func (db *DB) UpdateGameResult(winner, loser string, gameID int64) ([]RatingChange, error) {
 	tx, err := db.Pool.Begin(context.Background())
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
 	}
 	defer tx.Rollback(context.Background())
 
+	ratings, err := ratingsForUpdate(tx, winner, loser)
+	if err != nil {
+		return nil, err
+	}
+	winnerRating, loserRating := db.Elo.Game(ratings[winner], ratings[loser], rating.Win)
+
 	winnerQuery := `
 	UPDATE players
-	SET wins = wins + 1, rating = rating + 25, updated_at = CURRENT_TIMESTAMP
+	SET wins = wins + 1, rating = $2, updated_at = CURRENT_TIMESTAMP
 	WHERE username = $1
 	`
-	_, err = tx.Exec(context.Background(), winnerQuery, winner)
+	_, err = tx.Exec(context.Background(), winnerQuery, winner, winnerRating)
 	if err != nil {
-		return fmt.Errorf("failed to update winner: %v", err)
+		return nil, fmt.Errorf("failed to update winner: %v", err)
 	}
 
 	loserQuery := `
 	UPDATE players
-	SET losses = losses + 1, rating = GREATEST(rating - 15, 0), updated_at = CURRENT_TIMESTAMP
+	SET losses = losses + 1, rating = $2, updated_at = CURRENT_TIMESTAMP
 	WHERE username = $1
 	`
-	_, err = tx.Exec(context.Background(), loserQuery, loser)
+	_, err = tx.Exec(context.Background(), loserQuery, loser, loserRating)
 	if err != nil {
-		return fmt.Errorf("failed to update loser: %v", err)
+		return nil, fmt.Errorf("failed to update loser: %v", err)
+	}
+
+	changes := []RatingChange{
+		newRatingChange(winner, ratings[winner], winnerRating),
+		newRatingChange(loser, ratings[loser], loserRating),
+	}
+	if err := insertRatingHistory(tx, gameID, changes); err != nil {
+		return nil, err
 	}
 
 	if err := tx.Commit(context.Background()); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	return nil
+	return changes, nil
 }
 
-func (db *DB) UpdateDraw(player1, player2 string) error {
+// UpdateDraw records a draw for both players and moves their ratings by Elo,
+// writing both changes to rating_history like UpdateGameResult.
+func (db *DB) UpdateDraw(player1, player2 string, gameID int64) ([]RatingChange, error) {
 	tx, err := db.Pool.Begin(context.Background())
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
 	}
 	defer tx.Rollback(context.Background())
 
+	ratings, err := ratingsForUpdate(tx, player1, player2)
+	if err != nil {
+		return nil, err
+	}
+	player1Rating, player2Rating := db.Elo.Game(ratings[player1], ratings[player2], rating.Draw)
+
 	query := `
 	UPDATE players
-	SET draws = draws + 1, rating = rating + 5, updated_at = CURRENT_TIMESTAMP
+	SET draws = draws + 1, rating = $2, updated_at = CURRENT_TIMESTAMP
 	WHERE username = $1
 	`
-	_, err = tx.Exec(context.Background(), query, player1)
+	_, err = tx.Exec(context.Background(), query, player1, player1Rating)
 	if err != nil {
-		return fmt.Errorf("failed to update player1: %v", err)
+		return nil, fmt.Errorf("failed to update player1: %v", err)
 	}
 
-	_, err = tx.Exec(context.Background(), query, player2)
+	_, err = tx.Exec(context.Background(), query, player2, player2Rating)
 	if err != nil {
-		return fmt.Errorf("failed to update player2: %v", err)
+		return nil, fmt.Errorf("failed to update player2: %v", err)
+	}
+
+	changes := []RatingChange{
+		newRatingChange(player1, ratings[player1], player1Rating),
+		newRatingChange(player2, ratings[player2], player2Rating),
+	}
+	if err := insertRatingHistory(tx, gameID, changes); err != nil {
+		return nil, err
 	}
 
 	if err := tx.Commit(context.Background()); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
+	return changes, nil
+}
+
+func insertRatingHistory(tx pgx.Tx, gameID int64, changes []RatingChange) error {
+	query := `
+	INSERT INTO rating_history (username, game_id, old_rating, new_rating, delta)
+	VALUES ($1, NULLIF($2::bigint, 0), $3, $4, $5)
+	`
+	for _, change := range changes {
+		_, err := tx.Exec(context.Background(), query,
+			change.Username, gameID, change.Before, change.After, change.Delta,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert rating history for %s: %v", change.Username, err)
+		}
+	}
+	return nil
+}
+
+// GetRatingHistory returns a player's rating changes, oldest first. A zero
+// from or to leaves that end of the range open.
+func (db *DB) GetRatingHistory(username string, from, to time.Time) ([]RatingHistoryEntry, error) {
+	query := `
+	SELECT id, username, game_id, old_rating, new_rating, delta, created_at
+	FROM rating_history
+	WHERE username = $1
+		AND ($2::timestamp IS NULL OR created_at >= $2)
+		AND ($3::timestamp IS NULL OR created_at <= $3)
+	ORDER BY created_at, id
+	`
+
+	var fromArg, toArg *time.Time
+	if !from.IsZero() {
+		fromArg = &from
+	}
+	if !to.IsZero() {
+		toArg = &to
+	}
+
+	rows, err := db.Pool.Query(context.Background(), query, username, fromArg, toArg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rating history: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []RatingHistoryEntry{}
+	for rows.Next() {
+		var e RatingHistoryEntry
+		if err := rows.Scan(
+			&e.ID, &e.Username, &e.GameID, &e.OldRating, &e.NewRating, &e.Delta, &e.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan rating history row: %v", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return entries, nil
+}
+
+// ratingsForUpdate reads and row-locks the current ratings of the given players.
+func ratingsForUpdate(tx pgx.Tx, usernames ...string) (map[string]int, error) {
+	query := `
+	SELECT username, rating
+	FROM players
+	WHERE username = ANY($1)
+	ORDER BY username
+	FOR UPDATE
+	`
+
+	rows, err := tx.Query(context.Background(), query, usernames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ratings: %v", err)
+	}
+	defer rows.Close()
+
+	ratings := make(map[string]int, len(usernames))
+	for rows.Next() {
+		var username string
+		var playerRating int
+		if err := rows.Scan(&username, &playerRating); err != nil {
+			return nil, fmt.Errorf("failed to scan rating row: %v", err)
+		}
+		ratings[username] = playerRating
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	for _, username := range usernames {
+		if _, ok := ratings[username]; !ok {
+			return nil, fmt.Errorf("player not found: %s", username)
+		}
+	}
+
+	return ratings, nil
+}
+
+func newRatingChange(username string, before, after int) RatingChange {
+	return RatingChange{
+		Username: username,
+		Before:   before,
+		After:    after,
+		Delta:    after - before,
+	}
+}
+
+func (db *DB) InsertGame(game *Game, moves []GameMove) (int64, error) {
+	tx, err := db.Pool.Begin(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	gameQuery := `
+	INSERT INTO games (room_id, red_player, blue_player, opponent_type, bot_difficulty, winner, result, termination, started_at, ended_at)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, $10)
+	RETURNING id
+	`
+	var gameID int64
+	err = tx.QueryRow(context.Background(), gameQuery,
+		game.RoomID, game.RedPlayer, game.BluePlayer, game.OpponentType, game.BotDifficulty,
+		game.Winner, game.Result, game.Termination, game.StartedAt, game.EndedAt,
+	).Scan(&gameID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert game: %v", err)
+	}
+
+	moveQuery := `
+	INSERT INTO game_moves (game_id, ply, column_index, player, color, played_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	for _, move := range moves {
+		_, err = tx.Exec(context.Background(), moveQuery,
+			gameID, move.Ply, move.Column, move.Player, move.Color, move.PlayedAt,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert move %d: %v", move.Ply, err)
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	game.ID = gameID
+	return gameID, nil
+}
+
+func (db *DB) GetGame(id int64) (*Game, error) {
+	query := `
+	SELECT id, room_id, red_player, blue_player, opponent_type, COALESCE(bot_difficulty, ''),
+		COALESCE(winner, ''), result, termination, started_at, ended_at
+	FROM games
+	WHERE id = $1
+	`
+
+	var g Game
+	err := db.Pool.QueryRow(context.Background(), query, id).Scan(
+		&g.ID, &g.RoomID, &g.RedPlayer, &g.BluePlayer, &g.OpponentType, &g.BotDifficulty,
+		&g.Winner, &g.Result, &g.Termination, &g.StartedAt, &g.EndedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game: %w", err)
+	}
+
+	return &g, nil
+}
+
+func (db *DB) GetGameMoves(gameID int64) ([]GameMove, error) {
+	query := `
+	SELECT ply, column_index, player, color, played_at
+	FROM game_moves
+	WHERE game_id = $1
+	ORDER BY ply
+	`
+
+	rows, err := db.Pool.Query(context.Background(), query, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query game moves: %v", err)
+	}
+	defer rows.Close()
+
+	moves := []GameMove{}
+	for rows.Next() {
+		var m GameMove
+		if err := rows.Scan(&m.Ply, &m.Column, &m.Player, &m.Color, &m.PlayedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan move row: %v", err)
+		}
+		moves = append(moves, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return moves, nil
+}
+
+// ListGamesByPlayer returns a player's games, newest first. Only games with
+// an id below beforeID are returned, unless beforeID is 0.
+func (db *DB) ListGamesByPlayer(username string, limit int, beforeID int64) ([]Game, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	query := `
+	SELECT id, room_id, red_player, blue_player, opponent_type, COALESCE(bot_difficulty, ''),
+		COALESCE(winner, ''), result, termination, started_at, ended_at
+	FROM games
+	WHERE (red_player = $1 OR blue_player = $1) AND ($2::bigint = 0 OR id < $2)
+	ORDER BY id DESC
+	LIMIT $3
+	`
+
+	rows, err := db.Pool.Query(context.Background(), query, username, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query games: %v", err)
+	}
+	defer rows.Close()
+
+	games := []Game{}
+	for rows.Next() {
+		var g Game
+		if err := rows.Scan(
+			&g.ID, &g.RoomID, &g.RedPlayer, &g.BluePlayer, &g.OpponentType, &g.BotDifficulty,
+			&g.Winner, &g.Result, &g.Termination, &g.StartedAt, &g.EndedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan game row: %v", err)
+		}
+		games = append(games, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return games, nil
+}
+
+// CreateUser creates an account for username, and its player if there is
+// none yet. A player left over from before accounts existed goes to
+// whoever registers the name first. passwordHash is empty for guests.
+func (db *DB) CreateUser(username, passwordHash string, guest bool) (*User, error) {
+	tx, err := db.Pool.Begin(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	playerQuery := `
+	INSERT INTO players (username)
+	VALUES ($1)
+	ON CONFLICT (username) DO NOTHING
+	`
+	if _, err := tx.Exec(context.Background(), playerQuery, username); err != nil {
+		return nil, fmt.Errorf("failed to create player: %v", err)
+	}
+
+	userQuery := `
+	INSERT INTO users (username, password_hash, is_guest)
+	VALUES ($1, NULLIF($2, ''), $3)
+	ON CONFLICT (username) DO NOTHING
+	RETURNING id, username, COALESCE(password_hash, ''), is_guest, created_at
+	`
+	var u User
+	err = tx.QueryRow(context.Background(), userQuery, username, passwordHash, guest).Scan(
+		&u.ID, &u.Username, &u.PasswordHash, &u.Guest, &u.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return &u, nil
+}
+
+func (db *DB) GetUserByUsername(username string) (*User, error) {
+	query := `
+	SELECT id, username, COALESCE(password_hash, ''), is_guest, created_at
+	FROM users
+	WHERE username = $1
+	`
+
+	var u User
+	err := db.Pool.QueryRow(context.Background(), query, username).Scan(
+		&u.ID, &u.Username, &u.PasswordHash, &u.Guest, &u.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	return &u, nil
+}
+
+// StoreRefreshToken saves the hash of a refresh token issued to userID.
+func (db *DB) StoreRefreshToken(userID int64, tokenHash string, expiresAt time.Time) error {
+	query := `
+	INSERT INTO refresh_tokens (token_hash, user_id, expires_at)
+	VALUES ($1, $2, $3)
+	`
+	if _, err := db.Pool.Exec(context.Background(), query, tokenHash, userID, expiresAt); err != nil {
+		return fmt.Errorf("failed to store refresh token: %v", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken revokes the refresh token with oldHash and stores
+// newHash in its place for the same user, whom it returns. A refresh token
+// can therefore be used only once.
+func (db *DB) RotateRefreshToken(oldHash, newHash string, expiresAt time.Time) (*User, error) {
+	tx, err := db.Pool.Begin(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	revokeQuery := `
+	UPDATE refresh_tokens AS t
+	SET revoked_at = CURRENT_TIMESTAMP
+	FROM users AS u
+	WHERE t.token_hash = $1 AND t.revoked_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP AND u.id = t.user_id
+	RETURNING u.id, u.username, COALESCE(u.password_hash, ''), u.is_guest, u.created_at
+	`
+	var u User
+	err = tx.QueryRow(context.Background(), revokeQuery, oldHash).Scan(
+		&u.ID, &u.Username, &u.PasswordHash, &u.Guest, &u.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
+
+	insertQuery := `
+	INSERT INTO refresh_tokens (token_hash, user_id, expires_at)
+	VALUES ($1, $2, $3)
+	`
+	if _, err := tx.Exec(context.Background(), insertQuery, newHash, u.ID, expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return &u, nil
+}
+
+// RevokeRefreshToken revokes the refresh token with tokenHash. Revoking an
+// unknown or already revoked token is not an error.
+func (db *DB) RevokeRefreshToken(tokenHash string) error {
+	query := `
+	UPDATE refresh_tokens
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE token_hash = $1 AND revoked_at IS NULL
+	`
+	if _, err := db.Pool.Exec(context.Background(), query, tokenHash); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,334 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+)
+
+///////////////////////////////////////
+// BOARD DIMENSIONS
+///////////////////////////////////////
+
+const (
+	Columns = 7
+	Rows    = 6
+	// ConnectLength is the number of discs in a line needed to win.
+	ConnectLength = 4
+	// MaxMoves is the number of plies it takes to fill the board.
+	MaxMoves = Columns * Rows
+)
+
+var (
+	ErrColumnOutOfRange = errors.New("column out of range")
+	ErrColumnFull       = errors.New("column is full")
+	ErrGameOver         = errors.New("game is over")
+	ErrNoMoves          = errors.New("no moves to undo")
+)
+
+///////////////////////////////////////
+// PLAYER AND CELL ENUMS
+///////////////////////////////////////
+
+// Player identifies a side. Red always moves first.
+type Player int8
+
+const (
+	NoPlayer Player = iota
+	Red
+	Blue
+)
+
+// Cell is the content of a single board square.
+type Cell int8
+
+const (
+	Empty Cell = iota
+	RedDisc
+	BlueDisc
+)
+
+func (p Player) Other() Player {
+	switch p {
+	case Red:
+		return Blue
+	case Blue:
+		return Red
+	}
+	return NoPlayer
+}
+
+func (p Player) Cell() Cell {
+	switch p {
+	case Red:
+		return RedDisc
+	case Blue:
+		return BlueDisc
+	}
+	return Empty
+}
+
+// String returns the wire name of the player ("red", "blue" or "").
+func (p Player) String() string {
+	switch p {
+	case Red:
+		return "red"
+	case Blue:
+		return "blue"
+	}
+	return ""
+}
+
+func (p Player) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Player) UnmarshalText(text []byte) error {
+	parsed, ok := ParsePlayer(string(text))
+	if !ok {
+		return fmt.Errorf("invalid player %q", text)
+	}
+	*p = parsed
+	return nil
+}
+
+// ParsePlayer converts a wire color into a Player. The empty string maps to NoPlayer.
+func ParsePlayer(s string) (Player, bool) {
+	switch s {
+	case "red":
+		return Red, true
+	case "blue":
+		return Blue, true
+	case "":
+		return NoPlayer, true
+	}
+	return NoPlayer, false
+}
+
+func (c Cell) Player() Player {
+	switch c {
+	case RedDisc:
+		return Red
+	case BlueDisc:
+		return Blue
+	}
+	return NoPlayer
+}
+
+// String returns the wire name of the cell as used in grid_data.
+func (c Cell) String() string {
+	switch c {
+	case RedDisc:
+		return "red"
+	case BlueDisc:
+		return "blue"
+	}
+	return "neutral"
+}
+
+func (c Cell) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Cell) UnmarshalText(text []byte) error {
+	parsed, ok := ParseCell(string(text))
+	if !ok {
+		return fmt.Errorf("invalid cell %q", text)
+	}
+	*c = parsed
+	return nil
+}
+
+func ParseCell(s string) (Cell, bool) {
+	switch s {
+	case "red":
+		return RedDisc, true
+	case "blue":
+		return BlueDisc, true
+	case "neutral":
+		return Empty, true
+	}
+	return Empty, false
+}
+
+///////////////////////////////////////
+// BOARD
+// A Board wraps a bitboard Position with the move history and the result.
+// Coordinates are column-major with row 0 at the top, the same layout as the
+// grid_data sent to clients, so discs land in the highest free row index.
+///////////////////////////////////////
+
+type Board struct {
+	pos    Position
+	moves  []int
+	winner Player
+}
+
+///////////////////////////////////////
+// NewBoard returns an empty board with Red to move.
+///////////////////////////////////////
+
+func NewBoard() *Board {
+	return &Board{
+		moves: make([]int, 0, MaxMoves),
+	}
+}
+
+///////////////////////////////////////
+// FromMoves replays a list of columns from the empty board.
+///////////////////////////////////////
+
+func FromMoves(moves []int) (*Board, error) {
+	b := NewBoard()
+	for i, col := range moves {
+		if _, err := b.Play(col); err != nil {
+			return nil, fmt.Errorf("move %d (column %d): %w", i+1, col, err)
+		}
+	}
+	return b, nil
+}
+
+func (b *Board) Clone() *Board {
+	clone := *b
+	clone.moves = make([]int, len(b.moves), MaxMoves)
+	copy(clone.moves, b.moves)
+	return &clone
+}
+
+// Position returns a copy of the underlying bitboard.
+func (b *Board) Position() Position {
+	return b.pos
+}
+
+// Turn returns the player to move.
+func (b *Board) Turn() Player {
+	return b.pos.Turn()
+}
+
+func (b *Board) Cell(col, row int) Cell {
+	return b.pos.Cell(col, row)
+}
+
+// Ply returns the number of discs played so far.
+func (b *Board) Ply() int {
+	return len(b.moves)
+}
+
+// Moves returns a copy of the columns played so far, in order.
+func (b *Board) Moves() []int {
+	moves := make([]int, len(b.moves))
+	copy(moves, b.moves)
+	return moves
+}
+
+///////////////////////////////////////
+// LandingRow returns the row a disc dropped into col would occupy,
+// or -1 if the column is full or out of range.
+///////////////////////////////////////
+
+func (b *Board) LandingRow(col int) int {
+	if !b.pos.CanPlay(col) {
+		return -1
+	}
+	return Rows - 1 - b.pos.Height(col)
+}
+
+///////////////////////////////////////
+// CanPlay reports why a disc cannot be dropped into col, or nil if it can.
+///////////////////////////////////////
+
+func (b *Board) CanPlay(col int) error {
+	if b.IsOver() {
+		return ErrGameOver
+	}
+	if col < 0 || col >= Columns {
+		return ErrColumnOutOfRange
+	}
+	if !b.pos.CanPlay(col) {
+		return ErrColumnFull
+	}
+	return nil
+}
+
+///////////////////////////////////////
+// Play drops a disc for the player to move into col and returns the row it
+// landed in. The turn passes to the other player.
+///////////////////////////////////////
+
+func (b *Board) Play(col int) (int, error) {
+	if err := b.CanPlay(col); err != nil {
+		return -1, err
+	}
+
+	row := b.LandingRow(col)
+	mover := b.pos.Turn()
+	b.pos.Play(col)
+	b.moves = append(b.moves, col)
+
+	if b.pos.HasWon(mover) {
+		b.winner = mover
+	}
+
+	return row, nil
+}
+
+///////////////////////////////////////
+// Undo takes back the last disc and returns its column.
+///////////////////////////////////////
+
+func (b *Board) Undo() (int, error) {
+	if len(b.moves) == 0 {
+		return -1, ErrNoMoves
+	}
+
+	col := b.moves[len(b.moves)-1]
+	b.moves = b.moves[:len(b.moves)-1]
+	b.pos.Undo(col)
+	b.winner = NoPlayer
+
+	return col, nil
+}
+
+///////////////////////////////////////
+// LegalMoves returns the columns that can still be played, left to right.
+///////////////////////////////////////
+
+func (b *Board) LegalMoves() []int {
+	if b.IsOver() {
+		return nil
+	}
+	return b.pos.LegalMoves()
+}
+
+// Winner returns the player who connected four, or NoPlayer.
+func (b *Board) Winner() Player {
+	return b.winner
+}
+
+// IsDraw reports whether the board is full with no winner.
+func (b *Board) IsDraw() bool {
+	return b.winner == NoPlayer && b.pos.IsFull()
+}
+
+func (b *Board) IsOver() bool {
+	return b.winner != NoPlayer || b.pos.IsFull()
+}
+
+///////////////////////////////////////
+// WinsAt reports whether dropping a disc for p into col would connect four.
+// The board is left unchanged.
+///////////////////////////////////////
+
+func (b *Board) WinsAt(col int, p Player) bool {
+	if p == NoPlayer || !b.pos.CanPlay(col) {
+		return false
+	}
+	return alignment(b.pos.Discs(p) | bottomBit(col)<<b.pos.Height(col))
+}
+
+///////////////////////////////////////
+// Grid returns the board in the column-major grid_data wire format.
+///////////////////////////////////////
+
+func (b *Board) Grid() [][]string {
+	return b.pos.Grid()
+}
@@ -0,0 +1,168 @@
+package game
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// A full board with no four in a row.
+var drawMoves = []int{4, 3, 6, 0, 1, 4, 5, 5, 1, 1, 5, 0, 1, 6, 0, 1, 5, 5, 1, 0, 4, 6, 3, 2, 6, 6, 0, 4, 6, 5, 2, 0, 4, 2, 4, 2, 2, 2, 3, 3, 3, 3}
+
+func TestPlayDropsToLowestRowAndPassesTurn(t *testing.T) {
+	b := NewBoard()
+	if b.Turn() != Red {
+		t.Fatalf("first turn = %v, want red", b.Turn())
+	}
+
+	row, err := b.Play(3)
+	if err != nil || row != Rows-1 {
+		t.Fatalf("Play(3) = %d, %v, want %d, nil", row, err, Rows-1)
+	}
+	row, err = b.Play(3)
+	if err != nil || row != Rows-2 {
+		t.Fatalf("second Play(3) = %d, %v, want %d, nil", row, err, Rows-2)
+	}
+
+	if b.Cell(3, Rows-1) != RedDisc || b.Cell(3, Rows-2) != BlueDisc || b.Cell(3, 0) != Empty {
+		t.Fatalf("column 3 = %v", b.Grid()[3])
+	}
+	if b.Turn() != Red || b.Ply() != 2 {
+		t.Fatalf("turn %v at ply %d, want red at ply 2", b.Turn(), b.Ply())
+	}
+	if !reflect.DeepEqual(b.Moves(), []int{3, 3}) {
+		t.Fatalf("Moves() = %v", b.Moves())
+	}
+}
+
+func TestPlayRejectsIllegalMoves(t *testing.T) {
+	b := NewBoard()
+	for _, col := range []int{-1, Columns} {
+		if _, err := b.Play(col); !errors.Is(err, ErrColumnOutOfRange) {
+			t.Errorf("Play(%d) error = %v, want ErrColumnOutOfRange", col, err)
+		}
+	}
+
+	for i := 0; i < Rows; i++ {
+		if _, err := b.Play(0); err != nil {
+			t.Fatalf("filling column 0: %v", err)
+		}
+	}
+	if _, err := b.Play(0); !errors.Is(err, ErrColumnFull) {
+		t.Errorf("Play on a full column error = %v, want ErrColumnFull", err)
+	}
+	if b.LandingRow(0) != -1 {
+		t.Errorf("LandingRow on a full column = %d, want -1", b.LandingRow(0))
+	}
+	if b.Ply() != Rows {
+		t.Errorf("rejected moves changed the ply to %d", b.Ply())
+	}
+}
+
+func TestWinInEveryDirection(t *testing.T) {
+	tests := []struct {
+		name   string
+		moves  []int
+		winner Player
+	}{
+		{"vertical", []int{0, 1, 0, 1, 0, 1, 0}, Red},
+		{"horizontal", []int{0, 0, 1, 1, 2, 2, 3}, Red},
+		{"rising diagonal", []int{0, 1, 1, 2, 2, 3, 2, 3, 3, 5, 3}, Red},
+		{"falling diagonal", []int{6, 5, 5, 4, 4, 3, 4, 3, 3, 1, 3}, Red},
+		{"blue", []int{0, 1, 0, 1, 0, 1, 6, 1}, Blue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last := len(tt.moves) - 1
+			b, err := FromMoves(tt.moves[:last])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Winner() != NoPlayer || b.IsOver() {
+				t.Fatalf("won before the last move")
+			}
+			if !b.WinsAt(tt.moves[last], tt.winner) {
+				t.Errorf("WinsAt(%d, %v) = false", tt.moves[last], tt.winner)
+			}
+			if _, err := b.Play(tt.moves[last]); err != nil {
+				t.Fatal(err)
+			}
+
+			if b.Winner() != tt.winner || !b.IsOver() || b.IsDraw() {
+				t.Errorf("winner %v over %v draw %v, want %v true false", b.Winner(), b.IsOver(), b.IsDraw(), tt.winner)
+			}
+			if b.LegalMoves() != nil {
+				t.Errorf("LegalMoves after a win = %v, want nil", b.LegalMoves())
+			}
+			if _, err := b.Play(tt.moves[0]); !errors.Is(err, ErrGameOver) {
+				t.Errorf("Play after a win error = %v, want ErrGameOver", err)
+			}
+		})
+	}
+}
+
+func TestFullBoardIsADraw(t *testing.T) {
+	b, err := FromMoves(drawMoves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.IsDraw() || !b.IsOver() || b.Winner() != NoPlayer {
+		t.Fatalf("draw %v over %v winner %v, want true true none", b.IsDraw(), b.IsOver(), b.Winner())
+	}
+	if len(b.LegalMoves()) != 0 {
+		t.Errorf("LegalMoves on a full board = %v", b.LegalMoves())
+	}
+}
+
+func TestUndoRestoresThePosition(t *testing.T) {
+	if _, err := NewBoard().Undo(); !errors.Is(err, ErrNoMoves) {
+		t.Fatalf("Undo on an empty board error = %v, want ErrNoMoves", err)
+	}
+
+	moves := []int{0, 1, 0, 1, 0, 1}
+	b, err := FromMoves(moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := b.Position()
+
+	b.Play(0)
+	if b.Winner() != Red {
+		t.Fatalf("winner = %v, want red", b.Winner())
+	}
+	col, err := b.Undo()
+	if err != nil || col != 0 {
+		t.Fatalf("Undo() = %d, %v, want 0, nil", col, err)
+	}
+
+	if b.Winner() != NoPlayer || b.IsOver() {
+		t.Errorf("Undo kept the win")
+	}
+	if b.Position() != before || !reflect.DeepEqual(b.Moves(), moves) {
+		t.Errorf("Undo left %v, want %v", b.Moves(), moves)
+	}
+}
+
+func TestFromMovesReportsTheBadMove(t *testing.T) {
+	_, err := FromMoves([]int{0, 0, 0, 0, 0, 0, 0})
+	if !errors.Is(err, ErrColumnFull) {
+		t.Fatalf("error = %v, want ErrColumnFull", err)
+	}
+	if err.Error() != "move 7 (column 0): column is full" {
+		t.Errorf("error = %q", err)
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	b, _ := FromMoves([]int{3, 3})
+	clone := b.Clone()
+	clone.Play(4)
+
+	if b.Ply() != 2 || b.Cell(4, Rows-1) != Empty {
+		t.Errorf("playing on the clone changed the original")
+	}
+	if clone.Ply() != 3 || clone.Cell(4, Rows-1) != RedDisc {
+		t.Errorf("clone did not take the move")
+	}
+}
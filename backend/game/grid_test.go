@@ -0,0 +1,74 @@
+package game
+
+///////////////////////////////////////
+// GRID REFERENCE
+// The [][]string grid rules the server used before Position. Tests check
+// Position against them and benchmarks compare the two.
+///////////////////////////////////////
+
+func gridCheckForWin(grid [][]string, color string) string {
+	for col := 0; col < len(grid); col++ {
+		for row := 0; row < len(grid[col])-3; row++ {
+			if grid[col][row] == color &&
+				grid[col][row+1] == color &&
+				grid[col][row+2] == color &&
+				grid[col][row+3] == color {
+				return color
+			}
+		}
+	}
+
+	for col := 0; col < len(grid)-3; col++ {
+		for row := 0; row < len(grid[col]); row++ {
+			if grid[col][row] == color &&
+				grid[col+1][row] == color &&
+				grid[col+2][row] == color &&
+				grid[col+3][row] == color {
+				return color
+			}
+		}
+	}
+
+	for col := 0; col < len(grid)-3; col++ {
+		for row := 0; row < len(grid[col])-3; row++ {
+			if grid[col][row] == color &&
+				grid[col+1][row+1] == color &&
+				grid[col+2][row+2] == color &&
+				grid[col+3][row+3] == color {
+				return color
+			}
+		}
+	}
+
+	for col := 0; col < len(grid)-3; col++ {
+		for row := 3; row < len(grid[col]); row++ {
+			if grid[col][row] == color &&
+				grid[col+1][row-1] == color &&
+				grid[col+2][row-2] == color &&
+				grid[col+3][row-3] == color {
+				return color
+			}
+		}
+	}
+
+	return ""
+}
+
+func gridLowestEmptyRow(grid [][]string, col int) int {
+	for row := len(grid[col]) - 1; row >= 0; row-- {
+		if grid[col][row] == "neutral" {
+			return row
+		}
+	}
+	return -1
+}
+
+func gridLegalMoves(grid [][]string) []int {
+	moves := []int{}
+	for col := range grid {
+		if gridLowestEmptyRow(grid, col) != -1 {
+			moves = append(moves, col)
+		}
+	}
+	return moves
+}
@@ -0,0 +1,265 @@
+package game
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+///////////////////////////////////////
+// BITBOARD LAYOUT
+// Each column takes Rows+1 bits, bottom row first; the extra bit on top of
+// every column stays empty so shifted masks never wrap into the next column.
+//
+//   6 13 20 27 34 41 48
+//   5 12 19 26 33 40 47   <- top row (grid row 0)
+//   ...
+//   0  7 14 21 28 35 42   <- bottom row (grid row Rows-1)
+///////////////////////////////////////
+
+const columnBits = Rows + 1
+
+var (
+	bottomMask = repeatColumns(1)
+	boardMask  = repeatColumns(1<<Rows - 1)
+)
+
+func repeatColumns(columnMask uint64) uint64 {
+	var mask uint64
+	for col := 0; col < Columns; col++ {
+		mask |= columnMask << (col * columnBits)
+	}
+	return mask
+}
+
+func bottomBit(col int) uint64 {
+	return 1 << (col * columnBits)
+}
+
+// ColumnMask returns every square of col.
+func ColumnMask(col int) uint64 {
+	return (1<<Rows - 1) << (col * columnBits)
+}
+
+///////////////////////////////////////
+// Windows holds every line of ConnectLength squares on the board as a mask.
+// Evaluation functions count discs inside them.
+///////////////////////////////////////
+
+var Windows = lineMasks()
+
+func lineMasks() []uint64 {
+	var masks []uint64
+	directions := [4][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for col := 0; col < Columns; col++ {
+		for height := 0; height < Rows; height++ {
+			for _, d := range directions {
+				endCol := col + d[0]*(ConnectLength-1)
+				endHeight := height + d[1]*(ConnectLength-1)
+				if endCol < 0 || endCol >= Columns || endHeight < 0 || endHeight >= Rows {
+					continue
+				}
+				var mask uint64
+				for i := 0; i < ConnectLength; i++ {
+					mask |= bottomBit(col+d[0]*i) << (height + d[1]*i)
+				}
+				masks = append(masks, mask)
+			}
+		}
+	}
+	return masks
+}
+
+///////////////////////////////////////
+// POSITION
+// A compact value type for search: one disc mask per player plus the
+// height of every column. Copying a Position is cheap.
+///////////////////////////////////////
+
+type Position struct {
+	discs   [2]uint64
+	heights [Columns]int8
+	ply     int
+}
+
+func NewPosition() Position {
+	return Position{}
+}
+
+///////////////////////////////////////
+// PositionFromGrid builds a Position from column-major grid_data with row 0
+// at the top. It rejects floating discs and impossible disc counts.
+///////////////////////////////////////
+
+func PositionFromGrid(grid [][]string) (Position, error) {
+	var p Position
+	if len(grid) != Columns {
+		return p, fmt.Errorf("grid has %d columns, want %d", len(grid), Columns)
+	}
+
+	for col := 0; col < Columns; col++ {
+		if len(grid[col]) != Rows {
+			return p, fmt.Errorf("column %d has %d rows, want %d", col, len(grid[col]), Rows)
+		}
+		for height := 0; height < Rows; height++ {
+			cell, ok := ParseCell(grid[col][Rows-1-height])
+			if !ok {
+				return p, fmt.Errorf("invalid cell %q at column %d", grid[col][Rows-1-height], col)
+			}
+			if cell == Empty {
+				continue
+			}
+			if int(p.heights[col]) != height {
+				return p, fmt.Errorf("floating disc in column %d", col)
+			}
+			p.discs[cell.Player()-1] |= bottomBit(col) << height
+			p.heights[col]++
+			p.ply++
+		}
+	}
+
+	red, blue := bits.OnesCount64(p.discs[0]), bits.OnesCount64(p.discs[1])
+	if red != blue && red != blue+1 {
+		return p, fmt.Errorf("impossible disc counts: %d red, %d blue", red, blue)
+	}
+
+	return p, nil
+}
+
+// Grid returns the position in the column-major grid_data wire format.
+func (p *Position) Grid() [][]string {
+	grid := make([][]string, Columns)
+	for col := range grid {
+		grid[col] = make([]string, Rows)
+		for row := range grid[col] {
+			grid[col][row] = p.Cell(col, row).String()
+		}
+	}
+	return grid
+}
+
+// Cell returns the content of (col, row) in grid coordinates, row 0 at the top.
+func (p *Position) Cell(col, row int) Cell {
+	bit := bottomBit(col) << (Rows - 1 - row)
+	switch {
+	case p.discs[0]&bit != 0:
+		return RedDisc
+	case p.discs[1]&bit != 0:
+		return BlueDisc
+	}
+	return Empty
+}
+
+// Turn returns the player to move. Red always moves first.
+func (p *Position) Turn() Player {
+	if p.ply%2 == 0 {
+		return Red
+	}
+	return Blue
+}
+
+func (p *Position) Ply() int {
+	return p.ply
+}
+
+func (p *Position) Height(col int) int {
+	return int(p.heights[col])
+}
+
+// Mask returns every occupied square.
+func (p *Position) Mask() uint64 {
+	return p.discs[0] | p.discs[1]
+}
+
+// Discs returns the squares occupied by player.
+func (p *Position) Discs(player Player) uint64 {
+	return p.discs[player-1]
+}
+
+///////////////////////////////////////
+// Key uniquely identifies the position: the discs of the player to move
+// plus the occupied mask. It fits in 49 bits and is used to index
+// transposition tables.
+///////////////////////////////////////
+
+func (p *Position) Key() uint64 {
+	return p.discs[p.ply%2] + p.Mask()
+}
+
+func (p *Position) CanPlay(col int) bool {
+	return col >= 0 && col < Columns && p.heights[col] < Rows
+}
+
+// LegalMask returns the squares where a disc can land next.
+func (p *Position) LegalMask() uint64 {
+	return (p.Mask() + bottomMask) & boardMask
+}
+
+// LegalMoves returns the playable columns, left to right.
+func (p *Position) LegalMoves() []int {
+	moves := make([]int, 0, Columns)
+	for col := 0; col < Columns; col++ {
+		if p.heights[col] < Rows {
+			moves = append(moves, col)
+		}
+	}
+	return moves
+}
+
+///////////////////////////////////////
+// Play drops a disc for the player to move into col. The caller must check
+// CanPlay first.
+///////////////////////////////////////
+
+func (p *Position) Play(col int) {
+	p.discs[p.ply%2] |= bottomBit(col) << p.heights[col]
+	p.heights[col]++
+	p.ply++
+}
+
+// Undo removes the top disc of col, which must be the last disc played.
+func (p *Position) Undo(col int) {
+	p.ply--
+	p.heights[col]--
+	p.discs[p.ply%2] &^= bottomBit(col) << p.heights[col]
+}
+
+// IsWinningMove reports whether the player to move connects four by playing col.
+func (p *Position) IsWinningMove(col int) bool {
+	discs := p.discs[p.ply%2] | (bottomBit(col) << p.heights[col])
+	return alignment(discs)
+}
+
+// HasWon reports whether player has four in a row.
+func (p *Position) HasWon(player Player) bool {
+	return alignment(p.discs[player-1])
+}
+
+// IsFull reports whether every square is occupied.
+func (p *Position) IsFull() bool {
+	return p.ply == MaxMoves
+}
+
+///////////////////////////////////////
+// alignment checks the four directions with two shifts each.
+///////////////////////////////////////
+
+func alignment(discs uint64) bool {
+	// horizontal
+	m := discs & (discs >> columnBits)
+	if m&(m>>(2*columnBits)) != 0 {
+		return true
+	}
+	// diagonal, rising to the right
+	m = discs & (discs >> (columnBits + 1))
+	if m&(m>>(2*(columnBits+1))) != 0 {
+		return true
+	}
+	// diagonal, falling to the right
+	m = discs & (discs >> (columnBits - 1))
+	if m&(m>>(2*(columnBits-1))) != 0 {
+		return true
+	}
+	// vertical
+	m = discs & (discs >> 1)
+	return m&(m>>2) != 0
+}
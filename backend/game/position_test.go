@@ -0,0 +1,185 @@
+package game
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// TestPositionMatchesGrid plays random games and checks every answer the
+// bitboard gives against the grid reference.
+func TestPositionMatchesGrid(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		pos := NewPosition()
+		for !pos.IsFull() {
+			grid := pos.Grid()
+			if !reflect.DeepEqual(pos.LegalMoves(), gridLegalMoves(grid)) {
+				t.Fatalf("LegalMoves = %v, grid says %v", pos.LegalMoves(), gridLegalMoves(grid))
+			}
+
+			moves := pos.LegalMoves()
+			col := moves[rng.Intn(len(moves))]
+			mover := pos.Turn()
+			winning := pos.IsWinningMove(col)
+			before := pos
+
+			pos.Play(col)
+			grid = pos.Grid()
+			if grid[col][gridLowestEmptyRow(before.Grid(), col)] != mover.Cell().String() {
+				t.Fatalf("disc for %v did not land in column %d", mover, col)
+			}
+			gridWon := gridCheckForWin(grid, mover.Cell().String()) != ""
+			if pos.HasWon(mover) != gridWon || winning != gridWon {
+				t.Fatalf("after %v plays %d: HasWon %v IsWinningMove %v, grid says %v\n%v",
+					mover, col, pos.HasWon(mover), winning, gridWon, grid)
+			}
+
+			undone := pos
+			undone.Undo(col)
+			if undone != before {
+				t.Fatalf("Undo(%d) did not restore the position", col)
+			}
+			if gridWon {
+				break
+			}
+		}
+	}
+}
+
+func TestPositionFromGrid(t *testing.T) {
+	board, err := FromMoves(benchMoves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos, err := PositionFromGrid(board.Grid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != board.Position() {
+		t.Errorf("PositionFromGrid(Grid()) does not round trip")
+	}
+
+	blank := NewPosition()
+	empty := blank.Grid()
+	floating := blank.Grid()
+	floating[2][0] = "red"
+	tooManyBlue := blank.Grid()
+	tooManyBlue[0][Rows-1] = "blue"
+	badCell := blank.Grid()
+	badCell[0][Rows-1] = "green"
+
+	tests := []struct {
+		name string
+		grid [][]string
+	}{
+		{"missing column", empty[:Columns-1]},
+		{"short column", append(empty[:Columns-1:Columns-1], make([]string, Rows-1))},
+		{"floating disc", floating},
+		{"blue moved first", tooManyBlue},
+		{"unknown cell", badCell},
+	}
+	for _, tt := range tests {
+		if _, err := PositionFromGrid(tt.grid); err == nil {
+			t.Errorf("%s: PositionFromGrid accepted it", tt.name)
+		}
+	}
+}
+
+func TestKeyIgnoresMoveOrder(t *testing.T) {
+	a, _ := FromMoves([]int{0, 1, 2, 3})
+	b, _ := FromMoves([]int{2, 3, 0, 1})
+	c, _ := FromMoves([]int{1, 0, 3, 2})
+	pa, pb, pc := a.Position(), b.Position(), c.Position()
+
+	if pa.Key() != pb.Key() {
+		t.Errorf("the same position reached two ways has two keys")
+	}
+	if pa.Key() == pc.Key() {
+		t.Errorf("different positions share a key")
+	}
+}
+
+func TestWindows(t *testing.T) {
+	// 24 horizontal, 21 vertical and 12 in each diagonal direction
+	if len(Windows) != 69 {
+		t.Fatalf("%d windows, want 69", len(Windows))
+	}
+	for _, window := range Windows {
+		if !alignment(window) {
+			t.Errorf("window %#x is not four in a row", window)
+		}
+	}
+}
+
+// A mid-game position with no winner yet.
+var benchMoves = []int{3, 3, 2, 4, 4, 2, 5, 1, 1, 3, 2, 5, 6, 0}
+
+// Results are written to sinks so the compiler cannot drop the work.
+var (
+	boolSink  bool
+	maskSink  uint64
+	movesSink []int
+)
+
+func benchBoard(b *testing.B) *Board {
+	board, err := FromMoves(benchMoves)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return board
+}
+
+func BenchmarkWinCheckGrid(b *testing.B) {
+	grid := benchBoard(b).Grid()
+	for i := 0; i < b.N; i++ {
+		boolSink = gridCheckForWin(grid, "red") != "" || gridCheckForWin(grid, "blue") != ""
+	}
+}
+
+func BenchmarkWinCheckBitboard(b *testing.B) {
+	pos := benchBoard(b).Position()
+	for i := 0; i < b.N; i++ {
+		boolSink = pos.HasWon(Red) || pos.HasWon(Blue)
+	}
+}
+
+func BenchmarkMoveGenerationGrid(b *testing.B) {
+	grid := benchBoard(b).Grid()
+	for i := 0; i < b.N; i++ {
+		movesSink = gridLegalMoves(grid)
+	}
+}
+
+func BenchmarkMoveGenerationBitboard(b *testing.B) {
+	pos := benchBoard(b).Position()
+	for i := 0; i < b.N; i++ {
+		maskSink = pos.LegalMask()
+	}
+}
+
+func BenchmarkPlayEveryMoveGrid(b *testing.B) {
+	grid := benchBoard(b).Grid()
+	for i := 0; i < b.N; i++ {
+		for col := range grid {
+			row := gridLowestEmptyRow(grid, col)
+			if row == -1 {
+				continue
+			}
+			grid[col][row] = "red"
+			boolSink = gridCheckForWin(grid, "red") != ""
+			grid[col][row] = "neutral"
+		}
+	}
+}
+
+func BenchmarkPlayEveryMoveBitboard(b *testing.B) {
+	pos := benchBoard(b).Position()
+	for i := 0; i < b.N; i++ {
+		for col := 0; col < Columns; col++ {
+			if pos.CanPlay(col) {
+				boolSink = pos.IsWinningMove(col)
+			}
+		}
+	}
+}
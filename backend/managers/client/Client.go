@@ -0,0 +1,162 @@
+package client
+
+import (
+	"backend/managers/types"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+////////////////////////////////
+// Client wraps a websocket connection. A connection allows only one writer
+// at a time, so every message goes through a bounded send queue that a
+// single writer goroutine drains. Messages are encoded before they are
+// queued, so the sender may change anything a message refers to as soon
+// as Send returns. A client that cannot keep up is
+// disconnected instead of holding up the rooms sending to it.
+//
+// The writer also pings the peer. A connection that sends nothing, not
+// even a pong, for HeartbeatTimeout fails its next read, so a dead peer is
+// disconnected like any other.
+////////////////////////////////
+
+type Client struct {
+	Username string
+
+	conn      *websocket.Conn
+	send      chan queuedMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	latency   atomic.Int64 // round trip of the last answered ping, in nanoseconds
+}
+
+// SendBufferSize is how many messages can wait for a slow client before it
+// is disconnected.
+var SendBufferSize = 64
+
+// WriteTimeout is how long one write may take before the client is
+// considered gone.
+var WriteTimeout = 10 * time.Second
+
+// HeartbeatTimeout is how long a connection may stay silent before it is
+// disconnected. It is pinged three times in that time.
+var HeartbeatTimeout = 30 * time.Second
+
+// queuedMessage is an encoded message waiting for the writer. Its type is
+// kept for logging.
+type queuedMessage struct {
+	msgType string
+	data    []byte
+}
+
+var (
+	ErrClientClosed   = errors.New("client is closed")
+	ErrSendBufferFull = errors.New("client send buffer is full")
+)
+
+func NewClient(username string, conn *websocket.Conn) *Client {
+	c := &Client{
+		Username: username,
+		conn:     conn,
+		send:     make(chan queuedMessage, SendBufferSize),
+		done:     make(chan struct{}),
+	}
+	conn.SetReadDeadline(time.Now().Add(HeartbeatTimeout))
+	conn.SetPongHandler(c.pong)
+	go c.writeLoop()
+	return c
+}
+
+////////////////////////////////
+// Send encodes msg and queues it without waiting for it to be written. If
+// the queue is full the client is closed and ErrSendBufferFull returned.
+////////////////////////////////
+
+func (c *Client) Send(msg types.SocketServerMessageType) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.send <- queuedMessage{msgType: msg.Type, data: data}:
+		return nil
+	default:
+		println("Send buffer full, disconnecting", c.Username)
+		c.Close()
+		return ErrSendBufferFull
+	}
+}
+
+// ReadMessage reads the next message from the connection. Only the
+// connection's read loop may call it. Every message proves the peer is
+// still there.
+func (c *Client) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.conn.ReadMessage()
+	if err == nil {
+		c.conn.SetReadDeadline(time.Now().Add(HeartbeatTimeout))
+	}
+	return messageType, data, err
+}
+
+// Latency is the round trip of the last ping the peer answered, or 0
+// before the first one.
+func (c *Client) Latency() time.Duration {
+	return time.Duration(c.latency.Load())
+}
+
+// Close stops the writer and closes the connection, which also ends the
+// read loop. Messages still queued are dropped.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *Client) writeLoop() {
+	ticker := time.NewTicker(HeartbeatTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+				println("Error sending", msg.msgType, "to", c.Username, ":", err.Error())
+				c.Close()
+				return
+			}
+		case now := <-ticker.C:
+			// The pong echoes the send time, so the round trip can be
+			// measured without sharing state with the reader.
+			sentAt := []byte(strconv.FormatInt(now.UnixNano(), 10))
+			if err := c.conn.WriteControl(websocket.PingMessage, sentAt, now.Add(WriteTimeout)); err != nil {
+				println("Error pinging", c.Username, ":", err.Error())
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// pong runs on the read loop when the peer answers a ping.
+func (c *Client) pong(data string) error {
+	c.conn.SetReadDeadline(time.Now().Add(HeartbeatTimeout))
+	if sentAt, err := strconv.ParseInt(data, 10, 64); err == nil {
+		c.latency.Store(int64(time.Since(time.Unix(0, sentAt))))
+	}
+	return nil
+}
@@ -2,15 +2,14 @@ package client
 
 import (
 	"sync"
-
-	"github.com/gorilla/websocket"
 )
 
 type ClientManager struct {
-	clients        map[string]*websocket.Conn
+	clients        map[string]*Client
 	mu             sync.Mutex
-	connToclient   map[*websocket.Conn]string
+	connToclient   map[*Client]string
 	playingClients map[string]string
+	spectators     map[string]string
 }
 
 var (
@@ -26,9 +25,10 @@ var (
 func GetClientManager() *ClientManager {
 	once.Do(func() {
 		clientManager = &ClientManager{
-			connToclient:   make(map[*websocket.Conn]string),
-			clients:        make(map[string]*websocket.Conn),
+			connToclient:   make(map[*Client]string),
+			clients:        make(map[string]*Client),
 			playingClients: make(map[string]string),
+			spectators:     make(map[string]string),
 		}
 	})
 	return clientManager
@@ -39,13 +39,12 @@ func GetClientManager() *ClientManager {
 // It uses a mutex to ensure thread-safe access to the clients map.
 ////////////////////////////////
 
-func (cm *ClientManager) AddClient(username string, conn *websocket.Conn) {
+func (cm *ClientManager) AddClient(username string, conn *Client) {
 	cm.mu.Lock()
 	cm.clients[username] = conn
 	cm.connToclient[conn] = username
 	cm.mu.Unlock()
-	println(cm.connToclient[conn], " Added")
-	println(cm.clients[username], " Added")
+	println(username, " Added")
 }
 
 ///////////////////////////////
@@ -53,27 +52,23 @@ func (cm *ClientManager) AddClient(username string, conn *websocket.Conn) {
 // It closes the connection if it exists and deletes the entry from the clients map.
 ///////////////////////////////
 
-func (cm *ClientManager) RemoveClient(username string, conn *websocket.Conn) {
+func (cm *ClientManager) RemoveClient(username string, conn *Client) {
 	println("Connection ", conn)
+	cm.mu.Lock()
 	if username != "" {
-		if conn, exists := cm.clients[username]; exists {
-			println("Deleting Client with its connection ", username)
-			cm.mu.Lock()
-			delete(cm.connToclient, conn)
-			delete(cm.clients, username)
-			cm.mu.Unlock()
-			conn.Close()
-		}
+		conn = cm.clients[username]
 	} else {
-		if username, exists := cm.connToclient[conn]; exists {
-			println("Deleting Client with its connection ", username)
-			cm.mu.Lock()
-			delete(cm.connToclient, conn)
-			delete(cm.clients, username)
-			cm.mu.Unlock()
-			conn.Close()
-
-		}
+		username = cm.connToclient[conn]
+	}
+	_, exists := cm.clients[username]
+	if exists && conn != nil {
+		println("Deleting Client with its connection ", username)
+		delete(cm.connToclient, conn)
+		delete(cm.clients, username)
+	}
+	cm.mu.Unlock()
+	if exists && conn != nil {
+		conn.Close()
 	}
 }
 
@@ -82,14 +77,16 @@ func (cm *ClientManager) RemoveClient(username string, conn *websocket.Conn) {
 // It returns the connection and a boolean indicating if the client exists.
 ///////////////////////////////
 
-func (cm *ClientManager) GetClient(username string) (*websocket.Conn, bool) {
-
+func (cm *ClientManager) GetClient(username string) (*Client, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	conn, exists := cm.clients[username]
 	return conn, exists
 }
 
-func (cm *ClientManager) GetConnectionToUsername(conn *websocket.Conn) (string, bool) {
-
+func (cm *ClientManager) GetConnectionToUsername(conn *Client) (string, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	username, exists := cm.connToclient[conn]
 	return username, exists
 }
@@ -111,14 +108,9 @@ func (cm *ClientManager) AddPlayingClient(username string, roomId string) {
 
 func (cm *ClientManager) RemovePlayingClient(username string) {
 	println("Removing playing client:", username)
-	if cm.playingClients == nil {
-		return
-	}
-	if _, exists := cm.playingClients[username]; exists {
-		cm.mu.Lock()
-		delete(cm.playingClients, username)
-		cm.mu.Unlock()
-	}
+	cm.mu.Lock()
+	delete(cm.playingClients, username)
+	cm.mu.Unlock()
 }
 
 ///////////////////////////////
@@ -127,9 +119,33 @@ func (cm *ClientManager) RemovePlayingClient(username string) {
 ///////////////////////////////
 
 func (cm *ClientManager) GetPlayingClient(username string) (string, bool) {
-	if cm.playingClients == nil {
-		return "", false
-	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	roomId, exists := cm.playingClients[username]
 	return roomId, exists
 }
+
+///////////////////////////////
+// AddSpectatingClient records which room a user is watching.
+// A user watches at most one room at a time.
+///////////////////////////////
+
+func (cm *ClientManager) AddSpectatingClient(username string, roomId string) {
+	cm.mu.Lock()
+	cm.spectators[username] = roomId
+	cm.mu.Unlock()
+	println("Added spectating client:", username)
+}
+
+func (cm *ClientManager) RemoveSpectatingClient(username string) {
+	cm.mu.Lock()
+	delete(cm.spectators, username)
+	cm.mu.Unlock()
+}
+
+func (cm *ClientManager) GetSpectatingClient(username string) (string, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	roomId, exists := cm.spectators[username]
+	return roomId, exists
+}
@@ -0,0 +1,253 @@
+package matchmaking
+
+import (
+	"backend/bot"
+	"backend/clock"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////
+// STRUCTS AND VARIABLES
+///////////////////////////////////////////////
+
+// DefaultRating is assumed for players without a stored rating.
+const DefaultRating = 1000
+
+var ErrAlreadyQueued = errors.New("player is already in the queue")
+
+// Preference says who a player is willing to play.
+type Preference struct {
+	HumanOnly bool          // never fall back to the bot
+	BotAfter  time.Duration // how long to wait for a human before the bot joins
+}
+
+// DefaultPreference matches the old behaviour of adding a bot after 10 seconds.
+var DefaultPreference = Preference{BotAfter: 10 * time.Second}
+
+type Ticket struct {
+	Username      string
+	Rating        int
+	Preference    Preference
+	BotDifficulty bot.Difficulty
+	TimeControl   clock.TimeControl // only players who chose the same time control are paired
+	JoinedAt      time.Time
+}
+
+// Match pairs two tickets. Opponent is nil when First plays the bot.
+// First has waited longest and moves first.
+type Match struct {
+	First    Ticket
+	Opponent *Ticket
+}
+
+type Config struct {
+	Interval      time.Duration // how often the queue is scanned
+	InitialWindow int           // rating difference accepted straight away
+	WindowGrowth  int           // rating points the window widens by per second of waiting
+	MaxWindow     int           // widest the window gets
+}
+
+var DefaultConfig = Config{
+	Interval:      500 * time.Millisecond,
+	InitialWindow: 50,
+	WindowGrowth:  25,
+	MaxWindow:     400,
+}
+
+type Queue struct {
+	config  Config
+	onMatch func(Match)
+
+	mu      sync.Mutex
+	tickets map[string]Ticket
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+//////////////////////////////////////////////
+// NewQueue creates a queue that reports every match to onMatch from its
+// own goroutine once Start is called.
+//////////////////////////////////////////////
+
+func NewQueue(config Config, onMatch func(Match)) *Queue {
+	return &Queue{
+		config:  config,
+		onMatch: onMatch,
+		tickets: make(map[string]Ticket),
+	}
+}
+
+//////////////////////////////////////////////
+// Start runs the matching loop until Stop is called.
+//////////////////////////////////////////////
+
+func (q *Queue) Start() {
+	q.mu.Lock()
+	if q.stop != nil {
+		q.mu.Unlock()
+		return
+	}
+	q.stop = make(chan struct{})
+	stop := q.stop
+	q.mu.Unlock()
+
+	q.stopped.Add(1)
+	go func() {
+		defer q.stopped.Done()
+		ticker := time.NewTicker(q.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				for _, match := range q.Tick(now) {
+					q.onMatch(match)
+				}
+			}
+		}
+	}()
+}
+
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	if q.stop == nil {
+		q.mu.Unlock()
+		return
+	}
+	close(q.stop)
+	q.stop = nil
+	q.mu.Unlock()
+	q.stopped.Wait()
+}
+
+//////////////////////////////////////////////
+// Enqueue adds a ticket. A zero JoinedAt is set to now.
+//////////////////////////////////////////////
+
+func (q *Queue) Enqueue(ticket Ticket) error {
+	if ticket.JoinedAt.IsZero() {
+		ticket.JoinedAt = time.Now()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, exists := q.tickets[ticket.Username]; exists {
+		return ErrAlreadyQueued
+	}
+	q.tickets[ticket.Username] = ticket
+	return nil
+}
+
+// Cancel removes a player from the queue and reports whether they were in it.
+func (q *Queue) Cancel(username string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, exists := q.tickets[username]
+	delete(q.tickets, username)
+	return exists
+}
+
+func (q *Queue) Contains(username string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, exists := q.tickets[username]
+	return exists
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tickets)
+}
+
+//////////////////////////////////////////////
+// Window returns the rating difference a ticket accepts at time now.
+//////////////////////////////////////////////
+
+func (q *Queue) Window(ticket Ticket, now time.Time) int {
+	waited := now.Sub(ticket.JoinedAt).Seconds()
+	if waited < 0 {
+		waited = 0
+	}
+	window := q.config.InitialWindow + int(waited*float64(q.config.WindowGrowth))
+	return min(window, q.config.MaxWindow)
+}
+
+//////////////////////////////////////////////
+// Tick runs one matching pass as of now and removes the matched tickets.
+// Players who have waited longest are paired first, each with the closest
+// rated player on the same time control whose window also accepts them.
+// Anyone still unmatched past their BotAfter is matched with the bot.
+//////////////////////////////////////////////
+
+func (q *Queue) Tick(now time.Time) []Match {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting := make([]Ticket, 0, len(q.tickets))
+	for _, ticket := range q.tickets {
+		waiting = append(waiting, ticket)
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		if waiting[i].JoinedAt.Equal(waiting[j].JoinedAt) {
+			return waiting[i].Username < waiting[j].Username
+		}
+		return waiting[i].JoinedAt.Before(waiting[j].JoinedAt)
+	})
+
+	var matches []Match
+	matched := make(map[string]bool)
+
+	for i, ticket := range waiting {
+		if matched[ticket.Username] {
+			continue
+		}
+
+		best := -1
+		bestDiff := 0
+		for j := i + 1; j < len(waiting); j++ {
+			other := waiting[j]
+			if matched[other.Username] || other.TimeControl != ticket.TimeControl {
+				continue
+			}
+			diff := abs(ticket.Rating - other.Rating)
+			if diff > q.Window(ticket, now) || diff > q.Window(other, now) {
+				continue
+			}
+			if best == -1 || diff < bestDiff {
+				best, bestDiff = j, diff
+			}
+		}
+
+		if best != -1 {
+			opponent := waiting[best]
+			matched[ticket.Username] = true
+			matched[opponent.Username] = true
+			matches = append(matches, Match{First: ticket, Opponent: &opponent})
+			continue
+		}
+
+		if !ticket.Preference.HumanOnly && now.Sub(ticket.JoinedAt) >= ticket.Preference.BotAfter {
+			matched[ticket.Username] = true
+			matches = append(matches, Match{First: ticket})
+		}
+	}
+
+	for username := range matched {
+		delete(q.tickets, username)
+	}
+
+	return matches
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,172 @@
+package matchmaking
+
+import (
+	"backend/clock"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	Interval:      10 * time.Millisecond,
+	InitialWindow: 50,
+	WindowGrowth:  25,
+	MaxWindow:     400,
+}
+
+// humanOnly keeps the bot out so tests only see human pairings.
+var humanOnly = Preference{HumanOnly: true}
+
+func ticket(username string, rating int, joinedAt time.Time) Ticket {
+	return Ticket{
+		Username:    username,
+		Rating:      rating,
+		Preference:  humanOnly,
+		TimeControl: clock.DefaultTimeControl,
+		JoinedAt:    joinedAt,
+	}
+}
+
+func TestEnqueueAndCancel(t *testing.T) {
+	q := NewQueue(testConfig, nil)
+	if err := q.Enqueue(ticket("alice", 1000, time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ticket("alice", 1200, time.Time{})); err != ErrAlreadyQueued {
+		t.Errorf("second Enqueue error = %v, want ErrAlreadyQueued", err)
+	}
+	if !q.Contains("alice") || q.Len() != 1 {
+		t.Errorf("Contains = %v, Len = %d after one Enqueue", q.Contains("alice"), q.Len())
+	}
+
+	if !q.Cancel("alice") {
+		t.Errorf("Cancel(alice) = false")
+	}
+	if q.Cancel("alice") || q.Contains("alice") || q.Len() != 0 {
+		t.Errorf("alice is still queued after Cancel")
+	}
+}
+
+func TestWindowGrowsUpToMax(t *testing.T) {
+	q := NewQueue(testConfig, nil)
+	start := time.Now()
+	tk := ticket("alice", 1000, start)
+
+	for _, tt := range []struct {
+		waited time.Duration
+		want   int
+	}{
+		{-time.Second, 50},
+		{0, 50},
+		{2 * time.Second, 100},
+		{time.Minute, 400},
+	} {
+		if got := q.Window(tk, start.Add(tt.waited)); got != tt.want {
+			t.Errorf("Window after %v = %d, want %d", tt.waited, got, tt.want)
+		}
+	}
+}
+
+func TestTickPairsTheClosestRating(t *testing.T) {
+	q := NewQueue(testConfig, nil)
+	start := time.Now()
+	q.Enqueue(ticket("alice", 1000, start))
+	q.Enqueue(ticket("bob", 1040, start.Add(time.Millisecond)))
+	q.Enqueue(ticket("carol", 1010, start.Add(2*time.Millisecond)))
+
+	matches := q.Tick(start.Add(3 * time.Millisecond))
+	if len(matches) != 1 {
+		t.Fatalf("%d matches, want 1", len(matches))
+	}
+	m := matches[0]
+	if m.First.Username != "alice" || m.Opponent == nil || m.Opponent.Username != "carol" {
+		t.Fatalf("matched %+v, want alice first against carol", m)
+	}
+	if q.Contains("alice") || q.Contains("carol") || !q.Contains("bob") {
+		t.Errorf("matched tickets were not removed, or bob was")
+	}
+}
+
+func TestTickWaitsForTheWindowToWiden(t *testing.T) {
+	q := NewQueue(testConfig, nil)
+	start := time.Now()
+	q.Enqueue(ticket("alice", 1000, start))
+	q.Enqueue(ticket("bob", 1100, start))
+
+	if matches := q.Tick(start); len(matches) != 0 {
+		t.Fatalf("paired 100 points apart with a 50 point window: %+v", matches)
+	}
+	// after 2s both windows are 100
+	if matches := q.Tick(start.Add(2 * time.Second)); len(matches) != 1 {
+		t.Fatalf("%d matches once the window reached 100, want 1", len(matches))
+	}
+}
+
+func TestTickNeedsBothWindows(t *testing.T) {
+	q := NewQueue(testConfig, nil)
+	start := time.Now()
+	q.Enqueue(ticket("alice", 1000, start.Add(-time.Minute)))
+	q.Enqueue(ticket("bob", 1200, start))
+
+	if matches := q.Tick(start); len(matches) != 0 {
+		t.Fatalf("bob was paired outside his own window: %+v", matches)
+	}
+}
+
+func TestTickKeepsTimeControlsApart(t *testing.T) {
+	q := NewQueue(testConfig, nil)
+	start := time.Now()
+	alice := ticket("alice", 1000, start)
+	bob := ticket("bob", 1000, start)
+	bob.TimeControl = clock.TimeControl{Mode: clock.Correspondence, PerMove: clock.Day}
+	q.Enqueue(alice)
+	q.Enqueue(bob)
+
+	if matches := q.Tick(start.Add(time.Minute)); len(matches) != 0 {
+		t.Fatalf("paired different time controls: %+v", matches)
+	}
+}
+
+func TestTickFallsBackToTheBot(t *testing.T) {
+	q := NewQueue(testConfig, nil)
+	start := time.Now()
+	alice := ticket("alice", 1000, start)
+	alice.Preference = Preference{BotAfter: 10 * time.Second}
+	// bob is human only and on another time control, so alice can only get the bot
+	bob := ticket("bob", 1000, start)
+	bob.TimeControl = clock.TimeControl{Mode: clock.PerMove, PerMove: 30 * time.Second}
+	q.Enqueue(alice)
+	q.Enqueue(bob)
+
+	if matches := q.Tick(start.Add(9 * time.Second)); len(matches) != 0 {
+		t.Fatalf("bot joined before BotAfter: %+v", matches)
+	}
+	matches := q.Tick(start.Add(10 * time.Second))
+	if len(matches) != 1 || matches[0].First.Username != "alice" || matches[0].Opponent != nil {
+		t.Fatalf("matches = %+v, want alice against the bot", matches)
+	}
+	if matches := q.Tick(start.Add(time.Hour)); len(matches) != 0 || !q.Contains("bob") {
+		t.Errorf("a human only ticket was matched with the bot")
+	}
+}
+
+func TestStartReportsMatches(t *testing.T) {
+	matches := make(chan Match, 1)
+	q := NewQueue(testConfig, func(m Match) { matches <- m })
+	q.Start()
+	defer q.Stop()
+
+	q.Enqueue(ticket("alice", 1000, time.Time{}))
+	q.Enqueue(ticket("bob", 1000, time.Time{}))
+
+	select {
+	case m := <-matches:
+		if m.Opponent == nil {
+			t.Errorf("match %+v has no opponent", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no match reported")
+	}
+	if q.Len() != 0 {
+		t.Errorf("%d tickets left after the match", q.Len())
+	}
+}
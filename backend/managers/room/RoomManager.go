@@ -1,17 +1,28 @@
 package room
 
 import (
+	"backend/bot"
+	"backend/clock"
+	"backend/game"
 	"backend/managers/client"
 	"backend/managers/types"
+	"backend/session"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"log"
-	"math/rand"
+	"math"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"backend/db"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 )
 
 ////////////////////////////////////
@@ -22,26 +33,136 @@ type Room struct {
 	ID                  string
 	OpponentType        string
 	TotalPlayers        int
-	Players             map[string]*websocket.Conn // Maps player usernames to their presence in the room
-	DisconnectedPlayers map[string]time.Time       // Maps disconnected player usernames to their disconnect time
-	CurrentTurn         string                     // Username of the player whose turn it is
-	GridData            [][]string                 // 2D slice representing the game board
-	Status              string                     // waiting, playing, finished
+	Players             map[string]*client.Client // Maps player usernames to their presence in the room
+	DisconnectedPlayers map[string]time.Time      // Maps disconnected player usernames to their disconnect time
+	PlayerColors        map[string]game.Player    // Maps player usernames to the disc color of their seat
+	CurrentTurn         string                    // Username of the player whose turn it is
+	Board               *game.Board               // Authoritative game state
+	GridData            [][]string                // 2D slice representing the game board, mirrors Board for clients
+	Status              string                    // waiting, playing, finished
+	BotDifficulty       bot.Difficulty            // Level the bot plays at if one joins
+	botEngine           *bot.Engine
 	Winner              string
 	Loser               string
 	Draw                bool
+	Moves               []db.GameMove // Every move played, with the server time it was received
+	Termination         string        // Why the game ended, one of the db.Termination values
+	StartedAt           time.Time
+	EndedAt             time.Time
+	GameID              int64                      // ID of the stored game once it has been saved
+	RatingChanges       map[string]db.RatingChange // Rating before and after the game, by username
+	InviteCode          string                     // Code a friend joins a private room with, empty for matchmade rooms
+	InviteExpiresAt     time.Time
+	inviteTimer         *time.Timer
+	Spectators          map[string]*client.Client // Users watching the game, never counted in TotalPlayers
+	TimeControl         clock.TimeControl         // Chosen when the game is created
+	Clock               *clock.Clock              // Runs from the start of the game, nil until then
+	DrawOffer           string                    // Username with a draw offer open, empty if none
+	RematchOffer        string                    // Username who offered a rematch after the game, empty if none
+	RematchRoomID       string                    // Room the rematch is played in once accepted
+	Rated               bool                      // Whether the result changes stats and ratings
+	TakebackOffer       string                    // Username asking to take back their last move, empty if none
+	BotTakebacks        int                       // Takebacks granted by the bot this game
+	Chat                []types.ChatLine          // The last ChatHistoryLimit chat lines and emotes
+	chatSent            map[string][]time.Time    // When each player's recent chat lines were sent, for rate limiting
+	sessions            map[string]string         // ID of each player's current session token, by username
+	commands            chan func()               // Run one at a time by the room goroutine
+	quit                chan struct{}             // Closed when the room goroutine stops
+	closed              bool
+	ended               atomic.Bool // Set when the game ends, safe to read from any goroutine
 }
 
+// The maps only index the rooms and are guarded by mu. The lock is never
+// held while a room runs a command.
 type RoomManager struct {
-	WaitingRooms map[string]*Room
-	PlayingRooms map[string]*Room
-	roomIdToRoom map[string]*Room
+	mu            sync.Mutex
+	PlayingRooms  map[string]*Room
+	PrivateRooms  map[string]*Room // Private rooms waiting for their second player, by invite code
+	FinishedRooms map[string]*Room // Finished rooms kept for RematchWindow so the players can rematch
+	roomIdToRoom  map[string]*Room
+	rematchOffers map[string]string // Room ID of each player's open rematch offer, by username
+	Database      *db.DB            // Shared pool finished games are stored with, nil to store nothing
 }
 
 var roomManagerInstance *RoomManager = nil
 var PlayersNeeded int = 2
 
-var mu sync.Mutex
+// BotMoveDelay is the minimum time the bot appears to think before moving.
+var BotMoveDelay = 1 * time.Second
+
+// BotClockMargin is time the bot leaves on its clock for the move to reach
+// the room.
+var BotClockMargin = 200 * time.Millisecond
+
+var (
+	ErrGameNotInProgress = errors.New("game is not in progress")
+	ErrNotSeated         = errors.New("you are not seated in this room")
+	ErrNoDrawOffer       = errors.New("there is no draw offer to answer")
+	ErrDrawOfferOpen     = errors.New("your draw offer is still open")
+	ErrBotDeclinesDraw   = errors.New("the bot does not accept draws")
+	ErrAbortTooLate      = errors.New("the game can only be aborted before both players have moved")
+	ErrRematchExpired    = errors.New("the rematch window has closed")
+	ErrRematchOfferOpen  = errors.New("your rematch offer is still open")
+	ErrNoRematchOffer    = errors.New("there is no rematch offer to accept")
+	ErrOpponentLeft      = errors.New("your opponent is no longer connected")
+	ErrOpponentBusy      = errors.New("your opponent is in another game")
+	ErrInAnotherGame     = errors.New("you are already in another game")
+	ErrTakebacksDisabled = errors.New("takebacks are not allowed in rated games")
+	ErrNothingToTakeBack = errors.New("you have no move to take back")
+	ErrTakebackLimit     = errors.New("no takebacks left for this game")
+	ErrTakebackOfferOpen = errors.New("your takeback request is still open")
+	ErrNoTakebackOffer   = errors.New("there is no takeback request to answer")
+	ErrChatEmpty         = errors.New("chat message is empty")
+	ErrChatTooLong       = errors.New("chat message is too long")
+	ErrUnknownEmote      = errors.New("unknown emote")
+	ErrChatRateLimited   = errors.New("you are sending messages too quickly")
+	ErrChatClosed        = errors.New("chat is not open in this room")
+	ErrNotDisconnected   = errors.New("you were not disconnected from this room")
+	ErrRejoinExpired     = errors.New("you failed to reconnect within the time limit, the game is over")
+	ErrWrongSession      = errors.New("session token is for another player or room")
+	ErrSessionRevoked    = errors.New("session token has been revoked")
+)
+
+// Sessions signs the session tokens players reconnect with. The server
+// replaces it with one built from its config at startup.
+var Sessions = session.NewSigner(nil, session.DefaultTTL)
+
+// Chat limits. A player may send ChatRateLimit lines per ChatRateWindow.
+var (
+	ChatHistoryLimit = 50
+	MaxChatLength    = 200
+	ChatRateLimit    = 5
+	ChatRateWindow   = 10 * time.Second
+)
+
+// Emotes are the quick reactions a player can send.
+var Emotes = map[string]bool{
+	"good_luck":   true,
+	"well_played": true,
+	"good_game":   true,
+	"oops":        true,
+	"thinking":    true,
+	"wow":         true,
+}
+
+// BotTakebackLimit is how many takebacks the bot grants per game.
+var BotTakebackLimit = 3
+
+// RematchWindow is how long after a game ends either player can offer a rematch.
+var RematchWindow = 30 * time.Second
+
+// ReconnectWindow is how long a disconnected player has to rejoin before
+// they forfeit. Correspondence games have no window; the clock decides.
+var ReconnectWindow = 30 * time.Second
+
+// InviteExpiry is how long a private room waits for the invited player.
+var InviteExpiry = 10 * time.Minute
+
+// Invite codes leave out letters and digits that are easy to confuse.
+const (
+	inviteAlphabet   = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	inviteCodeLength = 6
+)
 
 // ////////////////////////////////////////////////
 // SINGLETON INSTACNE OF ROOM MANAGER
@@ -49,9 +170,11 @@ var mu sync.Mutex
 func GetRoomManager() *RoomManager {
 	if roomManagerInstance == nil {
 		roomManagerInstance = &RoomManager{
-			WaitingRooms: make(map[string]*Room),
-			PlayingRooms: make(map[string]*Room),
-			roomIdToRoom: make(map[string]*Room),
+			PlayingRooms:  make(map[string]*Room),
+			PrivateRooms:  make(map[string]*Room),
+			FinishedRooms: make(map[string]*Room),
+			roomIdToRoom:  make(map[string]*Room),
+			rematchOffers: make(map[string]string),
 		}
 	}
 	return roomManagerInstance
@@ -62,30 +185,88 @@ func GetRoomManager() *RoomManager {
 // CREATES A NEW ROOM AND RETURNS IT
 //////////////////////////////////////////////
 
-func CreateRoom(username string, conn *websocket.Conn) *Room {
+func CreateRoom(username string, conn *client.Client) *Room {
 	RoomId := uuid.New().String()
+	board := game.NewBoard()
 	Room := &Room{
 		ID:                  RoomId,
-		GridData:            make([][]string, 7),
-		Players:             make(map[string]*websocket.Conn),
+		Board:               board,
+		GridData:            board.Grid(),
+		Players:             make(map[string]*client.Client),
 		DisconnectedPlayers: make(map[string]time.Time),
+		PlayerColors:        make(map[string]game.Player),
+		Spectators:          make(map[string]*client.Client),
+		RatingChanges:       make(map[string]db.RatingChange),
 		Status:              "waiting",
+		BotDifficulty:       bot.DefaultDifficulty,
+		TimeControl:         clock.DefaultTimeControl,
 		CurrentTurn:         username,
 		TotalPlayers:        1,
 		Winner:              "",
 		Loser:               "",
 		Draw:                false,
+		commands:            make(chan func()),
+		quit:                make(chan struct{}),
 	}
 	Room.Players[username] = conn
+	go Room.run()
+
+	roomManagerInstance.mu.Lock()
 	roomManagerInstance.roomIdToRoom[RoomId] = Room
-	for i := range Room.GridData {
-		Room.GridData[i] = make([]string, 6)
-		for j := range Room.GridData[i] {
-			Room.GridData[i][j] = "neutral"
+	roomManagerInstance.mu.Unlock()
+
+	return Room
+}
+
+//////////////////////////////////////////////
+// ROOM GOROUTINE
+// Every room is owned by one goroutine that runs the commands sent to it
+// one at a time: moves, joins, disconnects, spectators and timers firing.
+// All reads and writes of a room's state happen there, so a room needs no
+// lock. Every Room method except Do expects to be called from a command.
+//////////////////////////////////////////////
+
+func (r *Room) run() {
+	for {
+		select {
+		case command := <-r.commands:
+			command()
+		case <-r.quit:
+			return
 		}
 	}
+}
 
-	return Room
+// Do runs command on the room goroutine and waits for it to finish. It
+// returns false without running it once the room has closed. A command
+// must not call Do on its own room.
+func (r *Room) Do(command func()) bool {
+	done := make(chan struct{})
+	select {
+	case r.commands <- func() {
+		defer close(done)
+		command()
+	}:
+	case <-r.quit:
+		return false
+	}
+	<-done
+	return true
+}
+
+// after runs command on the room goroutine once d has passed.
+func (r *Room) after(d time.Duration, command func()) {
+	time.AfterFunc(d, func() {
+		r.Do(command)
+	})
+}
+
+// close stops the room goroutine once the current command returns.
+func (r *Room) close() {
+	if !r.closed {
+		r.closed = true
+		close(r.quit)
+	}
 }
 
 /////////////////////////////////////////////////
@@ -94,12 +275,11 @@ func CreateRoom(username string, conn *websocket.Conn) *Room {
 
 func (r *Room) AddBot() {
 
-	println("Adding bot to room", r.ID)
-	mu.Lock()
+	println("Adding bot to room", r.ID, "at difficulty", string(r.BotDifficulty))
+	r.botEngine = bot.NewEngine(r.BotDifficulty)
 	r.OpponentType = "bot"
 	r.TotalPlayers++
 	r.Players["bot"] = nil
-	mu.Unlock()
 	if r.TotalPlayers == PlayersNeeded {
 		println("Total players reached", r.TotalPlayers)
 		r.ConverToPlaying()
@@ -108,149 +288,113 @@ func (r *Room) AddBot() {
 
 ///////////////////////////////////////////////
 // JOIN PLAYER TO ROOM
-// JOIN A PLAYER TO THE ROOM
+// REJOIN A DISCONNECTED PLAYER TO THE ROOM
+// THE PLAYER MUST HOLD THEIR CURRENT SESSION TOKEN FOR THE ROOM
 ///////////////////////////////////////////////
 
-func (r *Room) JoinPlayer(username string, conn *websocket.Conn) {
+func (r *Room) JoinPlayer(username string, conn *client.Client, token string) error {
 	println("Player rejoining room:", username)
 
-	mu.Lock()
-	defer mu.Unlock()
-
-	if disconnectTime, exists := r.DisconnectedPlayers[username]; exists {
-		if time.Since(disconnectTime) <= 30*time.Second {
-			delete(r.DisconnectedPlayers, username)
-
-			r.Players[username] = conn
-
-			playerNames := make([]string, 0, len(r.Players))
-			for playerName := range r.Players {
-				playerNames = append(playerNames, playerName)
-			}
+	disconnectTime, disconnected := r.DisconnectedPlayers[username]
+	if !disconnected {
+		return ErrNotDisconnected
+	}
 
-			var playerColor, opponentColor, opponentUsername string
-			if len(playerNames) >= 2 {
-				if r.OpponentType == "bot" {
-					playerColor = "red"
-					opponentColor = "blue"
-					opponentUsername = "bot"
-				} else {
-					if username == playerNames[0] {
-						playerColor = "red"
-						opponentColor = "blue"
-						opponentUsername = playerNames[1]
-					} else {
-						playerColor = "blue"
-						opponentColor = "red"
-						opponentUsername = playerNames[0]
-					}
-				}
-			}
+	if err := r.CheckSession(username, token); err != nil {
+		println("Session check failed for", username, ":", err.Error())
+		return err
+	}
 
-			conn.WriteJSON(types.SocketServerMessageType{
-				Type: "game_rejoined",
-				Data: map[string]interface{}{
-					"room_id":           r.ID,
-					"status":            r.Status,
-					"opponent_type":     r.OpponentType,
-					"current_turn":      r.CurrentTurn,
-					"total_players":     r.TotalPlayers,
-					"players":           playerNames,
-					"grid_data":         r.GridData,
-					"player_username":   username,
-					"player_color":      playerColor,
-					"opponent_color":    opponentColor,
-					"opponent_username": opponentUsername,
-				},
-			})
+	if r.forfeitsOnDisconnect() && time.Since(disconnectTime) > ReconnectWindow {
+		println("Rejoin time expired for player:", username)
 
-			for playerName, playerConn := range r.Players {
-				if playerName != username && playerName != "bot" {
-					playerConn.WriteJSON(types.SocketServerMessageType{
-						Type: "player_rejoined",
-						Data: map[string]interface{}{
-							"username": username,
-						},
-					})
-				}
-			}
+		opponentUsername := r.GetOpponent(username)
+		r.EndGame(opponentUsername, db.TerminationDisconnectTimeout)
 
-			println("Player successfully rejoined:", username)
-			return
-		} else {
-			println("Rejoin time expired for player:", username)
-
-			delete(r.DisconnectedPlayers, username)
-
-			for playerName := range r.Players {
-				if playerName != "bot" {
-					r.Winner = playerName
-					r.Status = "finished"
-
-					r.Players[playerName].WriteJSON(types.SocketServerMessageType{
-						Type: "game_update",
-						Data: map[string]interface{}{
-							"room_id": r.ID,
-							"status":  "finished",
-							"winner":  playerName,
-							"message": "Opponent failed to reconnect in time",
-						},
-					})
-					break
-				}
-			}
+		finishedMsg := types.NewServerMessage(types.MsgGameUpdate, types.GameUpdateData{
+			RoomID:        r.ID,
+			Status:        "finished",
+			Winner:        opponentUsername,
+			Termination:   r.Termination,
+			RatingChanges: r.RatingChanges,
+			Message:       "Opponent failed to reconnect in time",
+			Clock:         r.ClockState(),
+		})
+		if opponentUsername != "bot" {
+			r.Players[opponentUsername].Send(finishedMsg)
+		}
+		r.BroadcastToSpectators(finishedMsg)
 
-			conn.WriteJSON(types.SocketServerMessageType{
-				Type: "error",
-				Data: map[string]interface{}{
-					"error": "You failed to reconnect within the time limit. The game is over.",
-				},
-			})
+		delete(r.DisconnectedPlayers, username)
 
-			go func() {
-				time.Sleep(5 * time.Second)
-				for playerName := range r.Players {
-					client.GetClientManager().RemovePlayingClient(playerName)
-				}
-				r.DeleteRoom()
-			}()
+		r.after(5*time.Second, func() {
+			r.ReleasePlayers()
+			r.DeleteRoom()
+		})
 
-			return
-		}
+		return ErrRejoinExpired
 	}
 
+	delete(r.DisconnectedPlayers, username)
+
 	r.Players[username] = conn
-	r.TotalPlayers++
 
 	playerNames := make([]string, 0, len(r.Players))
 	for playerName := range r.Players {
 		playerNames = append(playerNames, playerName)
 	}
 
-	conn.WriteJSON(types.SocketServerMessageType{
-		Type: "game_joined",
-		Data: map[string]interface{}{
-			"room_id":       r.ID,
-			"status":        r.Status,
-			"current_turn":  r.CurrentTurn,
-			"total_players": r.TotalPlayers,
-			"players":       playerNames,
-			"grid_data":     r.GridData,
-		},
-	})
+	var playerColor, opponentColor game.Player
+	var opponentUsername string
+	if len(playerNames) >= 2 {
+		opponentUsername = r.GetOpponent(username)
+		playerColor = r.PlayerColors[username]
+		opponentColor = r.PlayerColors[opponentUsername]
+	}
+
+	conn.Send(types.NewServerMessage(types.MsgGameRejoined, types.GameStateData{
+		RoomID:           r.ID,
+		Status:           r.Status,
+		OpponentType:     r.OpponentType,
+		CurrentTurn:      r.CurrentTurn,
+		TotalPlayers:     r.TotalPlayers,
+		Players:          playerNames,
+		GridData:         r.GridData,
+		PlayerUsername:   username,
+		PlayerColor:      playerColor,
+		OpponentColor:    opponentColor,
+		OpponentUsername: opponentUsername,
+		Rated:            r.Rated,
+		Clock:            r.ClockState(),
+		Chat:             r.Chat,
+		LatencyMs:        r.LatencyMs(),
+		SessionToken:     r.issueSession(username), // replaces the token just used
+	}))
+
+	for playerName, playerConn := range r.Players {
+		if playerName != username && playerName != "bot" {
+			playerConn.Send(types.NewServerMessage(types.MsgPlayerRejoined, types.PlayerEventData{
+				Username: username,
+			}))
+		}
+	}
+	r.BroadcastToSpectators(types.NewServerMessage(types.MsgPlayerRejoined, types.PlayerEventData{
+		Username: username,
+	}))
+
+	println("Player successfully rejoined:", username)
+	return nil
 }
 
 ////////////////////////////////////////////////
 // ADDS A PLAYER TO THE ROOM
 ///////////////////////////////////////////////
 
-func (r *Room) AddPlayer(username string, conn *websocket.Conn) {
+func (r *Room) AddPlayer(username string, conn *client.Client) {
 	println("Adding player to room", username)
-	mu.Lock()
 	r.Players[username] = conn
 	r.TotalPlayers++
 	r.OpponentType = "human"
-	mu.Unlock()
 	if r.TotalPlayers == PlayersNeeded {
 		r.ConverToPlaying()
 	}
@@ -269,7 +413,13 @@ func (r *Room) StartGame() {
 		playerNames = append(playerNames, username)
 	}
 
-	var playerColor, botColor string
+	var playerColor, botColor game.Player
+
+	r.assignColors()
+	r.Clock = clock.New(r.TimeControl, func(color game.Player) {
+		r.Do(func() { r.flagFall(color) })
+	})
+	r.Clock.Start(game.Red, time.Now())
 
 	if r.OpponentType == "bot" {
 		var humanPlayer string
@@ -280,62 +430,55 @@ func (r *Room) StartGame() {
 			}
 		}
 
-		playerColor = "red"
-		botColor = "blue"
+		playerColor = r.PlayerColors[humanPlayer]
+		botColor = r.PlayerColors["bot"]
 
 		conn := r.Players[humanPlayer]
-		err := conn.WriteJSON(types.SocketServerMessageType{
-			Type: "game_started",
-			Data: map[string]interface{}{
-				"room_id":           r.ID,
-				"status":            r.Status,
-				"opponent_type":     r.OpponentType,
-				"current_turn":      r.CurrentTurn,
-				"total_players":     r.TotalPlayers,
-				"players":           playerNames,
-				"grid_data":         r.GridData,
-				"player_username":   humanPlayer,
-				"player_color":      playerColor,
-				"opponent_color":    botColor,
-				"opponent_username": "bot",
-			},
-		})
+		err := conn.Send(types.NewServerMessage(types.MsgGameStarted, types.GameStateData{
+			RoomID:           r.ID,
+			Status:           r.Status,
+			OpponentType:     r.OpponentType,
+			CurrentTurn:      r.CurrentTurn,
+			TotalPlayers:     r.TotalPlayers,
+			Players:          playerNames,
+			GridData:         r.GridData,
+			PlayerUsername:   humanPlayer,
+			PlayerColor:      playerColor,
+			OpponentColor:    botColor,
+			OpponentUsername: "bot",
+			BotDifficulty:    r.BotDifficulty,
+			Rated:            r.Rated,
+			Clock:            r.ClockState(),
+			LatencyMs:        r.LatencyMs(),
+			SessionToken:     r.issueSession(humanPlayer),
+		}))
 		if err != nil {
 			println("Error sending game started notification to", humanPlayer, ":", err.Error())
 		}
 	} else {
 		if len(playerNames) >= 2 {
-			firstPlayerColor := "red"
-			secondPlayerColor := "blue"
-
 			for username, conn := range r.Players {
-				var playerColor, opponentColor, opponentUsername string
-				if username == playerNames[0] {
-					playerColor = firstPlayerColor
-					opponentColor = secondPlayerColor
-					opponentUsername = playerNames[1]
-				} else {
-					playerColor = secondPlayerColor
-					opponentColor = firstPlayerColor
-					opponentUsername = playerNames[0]
-				}
-
-				err := conn.WriteJSON(types.SocketServerMessageType{
-					Type: "game_started",
-					Data: map[string]interface{}{
-						"room_id":           r.ID,
-						"status":            r.Status,
-						"opponent_type":     r.OpponentType,
-						"current_turn":      r.CurrentTurn,
-						"total_players":     r.TotalPlayers,
-						"players":           playerNames,
-						"grid_data":         r.GridData,
-						"player_username":   username,
-						"player_color":      playerColor,
-						"opponent_color":    opponentColor,
-						"opponent_username": opponentUsername,
-					},
-				})
+				opponentUsername := r.GetOpponent(username)
+				playerColor := r.PlayerColors[username]
+				opponentColor := r.PlayerColors[opponentUsername]
+
+				err := conn.Send(types.NewServerMessage(types.MsgGameStarted, types.GameStateData{
+					RoomID:           r.ID,
+					Status:           r.Status,
+					OpponentType:     r.OpponentType,
+					CurrentTurn:      r.CurrentTurn,
+					TotalPlayers:     r.TotalPlayers,
+					Players:          playerNames,
+					GridData:         r.GridData,
+					PlayerUsername:   username,
+					PlayerColor:      playerColor,
+					OpponentColor:    opponentColor,
+					OpponentUsername: opponentUsername,
+					Rated:            r.Rated,
+					Clock:            r.ClockState(),
+					LatencyMs:        r.LatencyMs(),
+					SessionToken:     r.issueSession(username),
+				}))
 				if err != nil {
 					println("Error sending game started notification to", username, ":", err.Error())
 				}
@@ -344,32 +487,100 @@ func (r *Room) StartGame() {
 	}
 
 	if r.OpponentType == "bot" && r.CurrentTurn == "bot" {
-		go r.MakeBotMove()
+		r.MakeBotMove()
 	}
 }
 
 /////////////////////////////////////////////////////
-// BOT MAKES A MOVE
+// ASSIGNS SEAT COLORS
+// THE PLAYER WHO MOVES FIRST PLAYS RED
 /////////////////////////////////////////////////////
 
-func (r *Room) MakeBotMove() {
-	time.Sleep(1 * time.Second)
+func (r *Room) assignColors() {
+	for username := range r.Players {
+		if username == r.CurrentTurn {
+			r.PlayerColors[username] = game.Red
+		} else {
+			r.PlayerColors[username] = game.Blue
+		}
+	}
+}
 
-	if r.Status != "playing" || r.CurrentTurn != "bot" {
+/////////////////////////////////////////////////////
+// GET OPPONENT OF A PLAYER IN THE ROOM
+/////////////////////////////////////////////////////
 
-		return
+func (r *Room) GetOpponent(username string) string {
+	for playerName := range r.Players {
+		if playerName != username {
+			return playerName
+		}
+	}
+	for playerName := range r.DisconnectedPlayers {
+		if playerName != username {
+			return playerName
+		}
 	}
+	return ""
+}
 
-	column, row := r.findBotMove()
+/////////////////////////////////////////////////////
+// BOT MAKES A MOVE
+// The bot searches and waits out BotMoveDelay on its own goroutine, so the
+// room keeps taking commands while it thinks. The move is played back on
+// the room goroutine if the position has not changed in the meantime.
+/////////////////////////////////////////////////////
 
+func (r *Room) MakeBotMove() {
 	if r.Status != "playing" || r.CurrentTurn != "bot" {
-
 		return
 	}
+	if r.botEngine == nil {
+		r.botEngine = bot.NewEngine(r.BotDifficulty)
+	}
+	engine := r.botEngine
+	board := r.Board.Clone()
+	ply := board.Ply()
+	budget := r.botBudget()
+
+	go func() {
+		thinkStart := time.Now()
+		column := engine.BestMoveWithin(board, budget)
+		if column == -1 {
+			return
+		}
+		if wait := min(BotMoveDelay, budget) - time.Since(thinkStart); wait > 0 {
+			time.Sleep(wait)
+		}
+		r.Do(func() {
+			if r.Status != "playing" || r.CurrentTurn != "bot" || r.Board.Ply() != ply {
+				return
+			}
+			r.playBotMove(column)
+		})
+	}()
+}
 
-	botColor := "blue"
+// botBudget is how long the bot may spend on this move: in Fischer its
+// share of the clock for the moves it may still have to make, and never
+// more than its clock holds less BotClockMargin.
+func (r *Room) botBudget() time.Duration {
+	if r.Clock == nil {
+		return math.MaxInt64 // no clock, only the level's own budget applies
+	}
+	budget := r.Clock.Remaining(r.PlayerColors["bot"], time.Now()) - BotClockMargin
+	if r.TimeControl.Mode == clock.Fischer {
+		movesLeft := (game.MaxMoves - r.Board.Ply() + 1) / 2
+		budget = min(budget, budget/time.Duration(movesLeft)+r.TimeControl.Increment)
+	}
+	return max(budget, 0)
+}
 
-	r.GridData[column][row] = botColor
+func (r *Room) playBotMove(column int) {
+	if _, err := r.PlayMove(column); err != nil {
+		println("Bot move rejected:", err.Error())
+		return
+	}
 
 	for username := range r.Players {
 		if username != "bot" {
@@ -378,157 +589,84 @@ func (r *Room) MakeBotMove() {
 		}
 	}
 
-	winner := r.checkForWin(r.GridData, botColor)
-
-	if winner != "" {
-		r.Status = "finished"
-		r.Winner = "bot"
+	if r.Board.Winner() == r.PlayerColors["bot"] {
+		r.EndGame("bot", db.TerminationConnectFour)
+	} else if r.Board.IsDraw() {
+		r.EndGame("", db.TerminationDraw)
 	}
 
-	for username, conn := range r.Players {
-		if username != "bot" {
-			updateMsg := types.SocketServerMessageType{
-				Type: "game_update",
-				Data: map[string]interface{}{
-					"room_id":      r.ID,
-					"status":       r.Status,
-					"current_turn": r.CurrentTurn,
-					"grid_data":    r.GridData,
-				},
-			}
+	update := types.GameUpdateData{
+		RoomID:      r.ID,
+		Status:      r.Status,
+		CurrentTurn: r.CurrentTurn,
+		GridData:    r.GridData,
+		Clock:       r.ClockState(),
+		LatencyMs:   r.LatencyMs(),
+	}
 
-			if r.Status == "finished" {
-				client.GetClientManager().RemovePlayingClient(username)
-				updateMsg.Data["winner"] = r.Winner
-				r.DeleteRoom()
-			}
+	if r.Status == "finished" {
+		r.ReleasePlayers()
+		r.addResult(&update)
+		if r.Draw {
+			update.Message = "The game ended in a draw."
+		}
+	}
 
-			err := conn.WriteJSON(updateMsg)
-			if err != nil {
-				println("Error sending game update to", username, ":", err.Error())
-			}
+	r.Broadcast(types.NewServerMessage(types.MsgGameUpdate, update))
 
-		}
+	if r.Status == "finished" {
+		r.DeleteRoom()
 	}
 }
 
 /////////////////////////////////////////////////////
-// FIND A VALID MOVE FOR THE BOT
+// PLAYS A MOVE FOR THE PLAYER TO MOVE AND REFRESHES GRID DATA
+// RETURNS THE ROW THE DISC LANDED IN
 /////////////////////////////////////////////////////
 
-func (r *Room) findBotMove() (int, int) {
-	// First try to find a winning move
-	for col := 0; col < len(r.GridData); col++ {
-		row := r.getLowestEmptyRow(col)
-		if row != -1 {
-			r.GridData[col][row] = "blue"
-			if r.checkForWin(r.GridData, "blue") != "" {
-				r.GridData[col][row] = "neutral"
-				return col, row
-			}
-			r.GridData[col][row] = "neutral"
-		}
-	}
-
-	// Then try to block player's winning move
-	for col := 0; col < len(r.GridData); col++ {
-		row := r.getLowestEmptyRow(col)
-		if row != -1 {
-			r.GridData[col][row] = "red"
-			if r.checkForWin(r.GridData, "red") != "" {
-				r.GridData[col][row] = "neutral"
-				return col, row
-			}
-			r.GridData[col][row] = "neutral"
-		}
+func (r *Room) PlayMove(column int) (int, error) {
+	now := time.Now()
+	// A clock that has stopped during the game has flagged, and flagFall
+	// is already on its way to the room.
+	if r.Clock != nil && (r.Clock.Flagged(now) || r.Clock.Running() == game.NoPlayer) {
+		return -1, clock.ErrFlagFall
 	}
 
-	validMoves := []struct {
-		col int
-		row int
-	}{}
-
-	for col := 0; col < len(r.GridData); col++ {
-		row := r.getLowestEmptyRow(col)
-		if row != -1 {
-			validMoves = append(validMoves, struct {
-				col int
-				row int
-			}{col, row})
-		}
+	color := r.Board.Turn()
+	row, err := r.Board.Play(column)
+	if err != nil {
+		return -1, err
 	}
-
-	if len(validMoves) > 0 {
-		randomMove := validMoves[rand.Intn(len(validMoves))]
-		return randomMove.col, randomMove.row
+	if r.Clock != nil && !r.Board.IsOver() {
+		r.Clock.Press(now)
 	}
-
-	return 0, 0
-}
-
-/////////////////////////////////////////////////////
-// GET THE LOWEST EMPTY ROW IN A COLUMN
-/////////////////////////////////////////////////////
-
-func (r *Room) getLowestEmptyRow(col int) int {
-	for row := len(r.GridData[col]) - 1; row >= 0; row-- {
-		if r.GridData[col][row] == "neutral" {
-			return row
-		}
+	// Moving instead of answering declines the opponent's draw offer.
+	mover := r.playerWithColor(color)
+	if r.DrawOffer != "" && r.DrawOffer != mover {
+		r.DrawOffer = ""
 	}
-	return -1
+	r.TakebackOffer = ""
+	r.GridData = r.Board.Grid()
+	r.Moves = append(r.Moves, db.GameMove{
+		Ply:      r.Board.Ply(),
+		Column:   column,
+		Player:   mover,
+		Color:    color.String(),
+		PlayedAt: now,
+	})
+	return row, nil
 }
 
 /////////////////////////////////////////////////////
-// CHECK FOR WIN CONDITION
+// GET THE PLAYER SEATED WITH A COLOR
 /////////////////////////////////////////////////////
 
-func (r *Room) checkForWin(grid [][]string, color string) string {
-
-	for col := 0; col < len(grid); col++ {
-		for row := 0; row < len(grid[col])-3; row++ {
-			if grid[col][row] == color &&
-				grid[col][row+1] == color &&
-				grid[col][row+2] == color &&
-				grid[col][row+3] == color {
-				return color
-			}
-		}
-	}
-
-	for col := 0; col < len(grid)-3; col++ {
-		for row := 0; row < len(grid[col]); row++ {
-			if grid[col][row] == color &&
-				grid[col+1][row] == color &&
-				grid[col+2][row] == color &&
-				grid[col+3][row] == color {
-				return color
-			}
-		}
-	}
-
-	for col := 0; col < len(grid)-3; col++ {
-		for row := 0; row < len(grid[col])-3; row++ {
-			if grid[col][row] == color &&
-				grid[col+1][row+1] == color &&
-				grid[col+2][row+2] == color &&
-				grid[col+3][row+3] == color {
-				return color
-			}
-		}
-	}
-
-	for col := 0; col < len(grid)-3; col++ {
-		for row := 3; row < len(grid[col]); row++ {
-			if grid[col][row] == color &&
-				grid[col+1][row-1] == color &&
-				grid[col+2][row-2] == color &&
-				grid[col+3][row-3] == color {
-				return color
-			}
+func (r *Room) playerWithColor(color game.Player) string {
+	for playerName, playerColor := range r.PlayerColors {
+		if playerColor == color {
+			return playerName
 		}
 	}
-
 	return ""
 }
 
@@ -540,169 +678,1179 @@ func (r *Room) DisconnectPlayer(username string) {
 	println("Disconnecting player from room:", username)
 
 	if r.Status == "playing" {
-		mu.Lock()
-
 		r.DisconnectedPlayers[username] = time.Now()
 
-		players := make(map[string]*websocket.Conn)
-		for playerName, conn := range r.Players {
-			players[playerName] = conn
+		message := fmt.Sprintf("Player disconnected. They have %d seconds to reconnect.", int(ReconnectWindow.Seconds()))
+		if !r.forfeitsOnDisconnect() {
+			message = "Player disconnected. They can reconnect until their clock runs out."
 		}
 
-		mu.Unlock()
-
 		// Notify remaining players about the disconnection
-		for playerName, conn := range players {
+		for playerName, conn := range r.Players {
 			println("Notifying player ", playerName, " about disconnection of ", username)
 			if playerName != "bot" && playerName != username {
-				conn.WriteJSON(types.SocketServerMessageType{
-					Type: "player_disconnected",
-					Data: map[string]interface{}{
-						"username": username,
-						"message":  "Player disconnected. They have 30 seconds to reconnect.",
-					},
-				})
+				conn.Send(types.NewServerMessage(types.MsgPlayerDisconnected, types.PlayerEventData{
+					Username: username,
+					Message:  message,
+				}))
 			}
 		}
+		r.BroadcastToSpectators(types.NewServerMessage(types.MsgPlayerDisconnected, types.PlayerEventData{
+			Username: username,
+			Message:  message,
+		}))
 		println("Players Notified about disconnection of ", username)
-		// Start a timer to check if the player reconnects within 30 seconds
-		go func(disconnectedUsername string) {
-			time.Sleep(30 * time.Second)
-
-			mu.Lock()
-			defer mu.Unlock()
-
+		if !r.forfeitsOnDisconnect() {
+			return
+		}
+		// Start a timer to check if the player reconnects in time
+		r.after(ReconnectWindow, func() {
 			// Check if the player is still disconnected
-			if _, stillDisconnected := r.DisconnectedPlayers[disconnectedUsername]; stillDisconnected {
+			if _, stillDisconnected := r.DisconnectedPlayers[username]; stillDisconnected {
 
-				r.PickWinner()
+				r.PickWinner(db.TerminationDisconnectTimeout)
 
 			}
-		}(username)
+		})
 
 	} else if r.Status == "waiting" {
 		client.GetClientManager().RemovePlayingClient(username)
-		mu.Lock()
 		r.DeleteRoom()
-		mu.Unlock()
 	}
 
 }
 
+// forfeitsOnDisconnect reports whether a player who stays away longer than
+// ReconnectWindow loses. A correspondence player may be away for days, so
+// only their clock can end the game.
+func (r *Room) forfeitsOnDisconnect() bool {
+	return r.TimeControl.Mode != clock.Correspondence
+}
+
 /////////////////////////////////////////////////////
 //PICK WINNER AFTER PLAYER MISSING FROM ROOM
 /////////////////////////////////////////////////////
 
-func (r *Room) PickWinner() {
+func (r *Room) PickWinner(termination string) {
 	println("Picking winner")
+	if r.Status == "finished" {
+		return
+	}
 	if len(r.DisconnectedPlayers) == 2 {
 		println("Both players disconnected")
+		r.EndGame("", termination)
+		r.BroadcastToSpectators(types.NewServerMessage(types.MsgGameUpdate, types.GameUpdateData{
+			RoomID:      r.ID,
+			Status:      r.Status,
+			GridData:    r.GridData,
+			Winner:      r.Winner,
+			Termination: r.Termination,
+			Message:     "Both players left the game.",
+		}))
 		r.DeleteRoom()
 		return
 	}
-	if r.Status == "finished" {
+
+	for username := range r.DisconnectedPlayers {
+		r.Forfeit(username, termination)
 		return
 	}
+}
 
-	for username := range r.Players {
-		if _, exists := r.DisconnectedPlayers[username]; !exists {
-			r.Winner = username
-			r.Status = "finished"
-			println("Winner is", r.Winner)
-			break
-		}
+//////////////////////////////////////////////
+// Forfeit ends the game as a loss for loser, tells everyone still
+// connected and closes the room. Abandonment, disconnect timeouts and
+// flag-fall all end here.
+//////////////////////////////////////////////
+
+func (r *Room) Forfeit(loser string, termination string) {
+	winner := r.GetOpponent(loser)
+	println("Winner is", winner)
+	r.finish(winner, termination, "")
+}
+
+//////////////////////////////////////////////
+// finish ends the game, sends the final game_update to every connected
+// player and spectator, and closes the room straight away.
+//////////////////////////////////////////////
+
+func (r *Room) finish(winner string, termination string, message string) {
+	if r.Status == "finished" {
+		return
+	}
+	r.EndGame(winner, termination)
+
+	update := types.GameUpdateData{
+		RoomID:      r.ID,
+		Status:      r.Status,
+		CurrentTurn: r.CurrentTurn,
+		GridData:    r.GridData,
+		Clock:       r.ClockState(),
+		Message:     message,
 	}
-	if r.Winner != "bot" {
-		r.UpdatePlayerStats(r.Winner)
+	r.addResult(&update)
+	updateMsg := types.NewServerMessage(types.MsgGameUpdate, update)
 
-		playerConn := r.Players[r.Winner]
-		playerName := r.Winner
-		updateMsg := types.SocketServerMessageType{
-			Type: "game_update",
-			Data: map[string]any{
-				"room_id":      r.ID,
-				"status":       r.Status,
-				"current_turn": r.CurrentTurn,
-				"grid_data":    r.GridData,
-			},
+	for playerName, playerConn := range r.Players {
+		if playerName == "bot" || playerConn == nil {
+			continue
 		}
-
-		if r.Status == "finished" {
-			updateMsg.Data["winner"] = r.Winner
+		if _, disconnected := r.DisconnectedPlayers[playerName]; disconnected {
+			continue
 		}
-
-		err := playerConn.WriteJSON(updateMsg)
-		if err != nil {
+		if err := playerConn.Send(updateMsg); err != nil {
 			println("Error sending game update to", playerName, ":", err.Error())
 		}
 	}
+	r.BroadcastToSpectators(updateMsg)
+	r.ReleasePlayers()
 	r.DeleteRoom()
 }
 
-////////////////////////////////////////////////////
-//DELETE ROOM FUNCTION
-//DELETES THE ROOM FROM THE WaitingRooms , PlayingRooms , roomIdToRoom
-////////////////////////////////////////////////////
+// addResult fills in how a finished game ended.
+func (r *Room) addResult(update *types.GameUpdateData) {
+	update.Winner = r.Winner
+	update.Draw = r.Draw
+	update.Termination = r.Termination
+	update.RatingChanges = r.RatingChanges
+}
 
-func (r *Room) DeleteRoom() {
-	println("Deleting room")
-	if r.Status == "waiting" {
-		delete(roomManagerInstance.WaitingRooms, r.ID)
-	} else {
-		delete(roomManagerInstance.PlayingRooms, r.ID)
+//////////////////////////////////////////////
+// GAME ACTIONS OTHER THAN MOVES
+// Resign, draw offers and abort can be sent on either player's turn.
+//////////////////////////////////////////////
+
+func (r *Room) checkSeated(username string) error {
+	if r.Status != "playing" {
+		return ErrGameNotInProgress
 	}
-	delete(roomManagerInstance.roomIdToRoom, r.ID)
+	if _, seated := r.PlayerColors[username]; !seated {
+		return ErrNotSeated
+	}
+	return nil
 }
 
-////////////////////////////////////////////////////
-// GET ROOM BY ID FUNCTION
-// RETURNS THE ROOM BY ID
-////////////////////////////////////////////////////
+func (r *Room) Resign(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	println("Player resigned:", username)
+	winner := r.GetOpponent(username)
+	r.finish(winner, db.TerminationResignation, username+" resigned.")
+	return nil
+}
 
-func GetRoomById(id string) *Room {
+//////////////////////////////////////////////
+// OfferDraw opens a draw offer to the opponent. If the opponent already
+// offered one, the two offers meet and the game is drawn.
+//////////////////////////////////////////////
 
-	return roomManagerInstance.roomIdToRoom[id]
+func (r *Room) OfferDraw(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	opponent := r.GetOpponent(username)
+	if opponent == "bot" {
+		return ErrBotDeclinesDraw
+	}
+
+	switch r.DrawOffer {
+	case opponent:
+		return r.AcceptDraw(username)
+	case username:
+		return ErrDrawOfferOpen
+	}
+	r.DrawOffer = username
+
+	offerMsg := types.NewServerMessage(types.MsgDrawOffered, types.PlayerEventData{
+		RoomID:   r.ID,
+		Username: username,
+	})
+	if conn := r.Players[opponent]; conn != nil {
+		conn.Send(offerMsg)
+	}
+	r.BroadcastToSpectators(offerMsg)
+	return nil
 }
 
-////////////////////////////////////////////////////
-// CONVERT WAITING ROOM TO PLAYING ROOM
-////////////////////////////////////////////////////
+func (r *Room) AcceptDraw(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	if r.DrawOffer == "" || r.DrawOffer == username {
+		return ErrNoDrawOffer
+	}
+	r.DrawOffer = ""
 
-func (r *Room) ConverToPlaying() {
-	println("Converting room to playing")
-	mu.Lock()
-	r.Status = "playing"
-	roomManagerInstance.PlayingRooms[r.ID] = r
-	delete(roomManagerInstance.WaitingRooms, r.ID)
-	mu.Unlock()
-	println("Starting playing game")
-	r.StartGame()
+	println("Draw agreed in room", r.ID)
+	r.finish("", db.TerminationDrawAgreement, "The players agreed to a draw.")
+	return nil
 }
 
-///////////////////////////////////////////
-//UPDATE PLAYER STATS FUNCTION
-//UPDATES THE PLAYER STATS IN THE DATABASE
-///////////////////////////////////////////
+func (r *Room) DeclineDraw(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	offeredBy := r.DrawOffer
+	if offeredBy == "" || offeredBy == username {
+		return ErrNoDrawOffer
+	}
+	r.DrawOffer = ""
 
-func (r *Room) UpdatePlayerStats(winner string) {
+	declineMsg := types.NewServerMessage(types.MsgDrawDeclined, types.PlayerEventData{
+		RoomID:   r.ID,
+		Username: username,
+	})
+	if conn := r.Players[offeredBy]; conn != nil {
+		conn.Send(declineMsg)
+	}
+	r.BroadcastToSpectators(declineMsg)
+	return nil
+}
 
-	println("Updating player stats for winner:", winner)
-	dbInstance, err := db.NewDB()
-	if err != nil {
-		log.Printf("Failed to connect to database: %v", err)
-		return
+//////////////////////////////////////////////
+// Abort cancels the game without a result. Either player can abort until
+// both have made a move; aborted games do not change ratings.
+//////////////////////////////////////////////
+
+func (r *Room) Abort(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	if r.Board.Ply() >= 2 {
+		return ErrAbortTooLate
 	}
-	defer dbInstance.Close()
+	println("Game aborted by", username)
+	r.finish("", db.TerminationAborted, username+" aborted the game.")
+	return nil
+}
 
-	if winner != "" {
-		var loser string
-		for playerName := range r.Players {
-			println("Player name:", playerName)
-			if playerName != winner && playerName != "bot" {
-				loser = playerName
-				break
-			}
-		}
+//////////////////////////////////////////////
+// TAKEBACKS
+// Not allowed in rated games. Against the bot a takeback is granted at once,
+// up to BotTakebackLimit per game, and removes the player's last move and
+// the bot's reply. Against a human the player who just moved asks, and the
+// opponent has to accept before that one move is removed.
+//////////////////////////////////////////////
+
+func (r *Room) RequestTakeback(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	if r.Rated {
+		return ErrTakebacksDisabled
+	}
+
+	opponent := r.GetOpponent(username)
+	if opponent == "bot" {
+		if r.CurrentTurn != username || !r.hasMoved(username) {
+			return ErrNothingToTakeBack
+		}
+		if r.BotTakebacks >= BotTakebackLimit {
+			return ErrTakebackLimit
+		}
+		r.BotTakebacks++
+		r.takeBack(2)
+		return nil
+	}
+
+	if len(r.Moves) == 0 || r.Moves[len(r.Moves)-1].Player != username {
+		return ErrNothingToTakeBack
+	}
+
+	if r.TakebackOffer == username {
+		return ErrTakebackOfferOpen
+	}
+	r.TakebackOffer = username
+
+	requestMsg := types.NewServerMessage(types.MsgTakebackRequested, types.PlayerEventData{
+		RoomID:   r.ID,
+		Username: username,
+	})
+	if conn := r.Players[opponent]; conn != nil {
+		conn.Send(requestMsg)
+	}
+	r.BroadcastToSpectators(requestMsg)
+	return nil
+}
+
+func (r *Room) AcceptTakeback(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	requestedBy := r.TakebackOffer
+	if requestedBy == "" || requestedBy == username {
+		return ErrNoTakebackOffer
+	}
+	r.TakebackOffer = ""
+
+	r.takeBack(1)
+	return nil
+}
+
+func (r *Room) DeclineTakeback(username string) error {
+	if err := r.checkSeated(username); err != nil {
+		return err
+	}
+	requestedBy := r.TakebackOffer
+	if requestedBy == "" || requestedBy == username {
+		return ErrNoTakebackOffer
+	}
+	r.TakebackOffer = ""
+
+	if conn := r.Players[requestedBy]; conn != nil {
+		conn.Send(types.NewServerMessage(types.MsgTakebackDeclined, types.PlayerEventData{
+			RoomID:   r.ID,
+			Username: username,
+		}))
+	}
+	return nil
+}
+
+func (r *Room) hasMoved(username string) bool {
+	for _, move := range r.Moves {
+		if move.Player == username {
+			return true
+		}
+	}
+	return false
+}
+
+//////////////////////////////////////////////
+// takeBack removes the last plies from the board and the move history,
+// hands the turn and the clock back and sends everyone the new board.
+//////////////////////////////////////////////
+
+func (r *Room) takeBack(plies int) {
+	for i := 0; i < plies && len(r.Moves) > 0; i++ {
+		if _, err := r.Board.Undo(); err != nil {
+			break
+		}
+		r.Moves = r.Moves[:len(r.Moves)-1]
+	}
+	r.GridData = r.Board.Grid()
+	r.CurrentTurn = r.playerWithColor(r.Board.Turn())
+	r.DrawOffer = ""
+	if r.Clock != nil {
+		r.Clock.Restart(r.Board.Turn(), time.Now())
+	}
+
+	println("Took back", plies, "plies in room", r.ID)
+	r.Broadcast(types.NewServerMessage(types.MsgGameUpdate, types.GameUpdateData{
+		RoomID:      r.ID,
+		Status:      r.Status,
+		CurrentTurn: r.CurrentTurn,
+		GridData:    r.GridData,
+		Clock:       r.ClockState(),
+		Takeback:    true,
+	}))
+}
+
+//////////////////////////////////////////////
+// CHAT
+// Seated players can chat and send emotes while the room exists. Lines go
+// to both players and every spectator, and the last ChatHistoryLimit are
+// kept so a reconnecting player or a new spectator sees recent chat.
+//////////////////////////////////////////////
+
+func (r *Room) SendChat(username string, text string) error {
+	text = strings.TrimSpace(strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, strings.ToValidUTF8(text, "")))
+	if text == "" {
+		return ErrChatEmpty
+	}
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		return ErrChatTooLong
+	}
+	return r.addChatLine(types.ChatLine{Kind: "chat", Username: username, Text: text})
+}
+
+func (r *Room) SendEmote(username string, emote string) error {
+	if !Emotes[emote] {
+		return ErrUnknownEmote
+	}
+	return r.addChatLine(types.ChatLine{Kind: "emote", Username: username, Emote: emote})
+}
+
+func (r *Room) addChatLine(line types.ChatLine) error {
+	if _, seated := r.PlayerColors[line.Username]; !seated || r.Status == "waiting" {
+		return ErrChatClosed
+	}
+
+	now := time.Now()
+	if r.chatSent == nil {
+		r.chatSent = make(map[string][]time.Time)
+	}
+	recent := r.chatSent[line.Username][:0]
+	for _, sentAt := range r.chatSent[line.Username] {
+		if now.Sub(sentAt) < ChatRateWindow {
+			recent = append(recent, sentAt)
+		}
+	}
+	if len(recent) >= ChatRateLimit {
+		r.chatSent[line.Username] = recent
+		return ErrChatRateLimited
+	}
+	r.chatSent[line.Username] = append(recent, now)
+
+	line.SentAt = now
+	r.Chat = append(r.Chat, line)
+	if len(r.Chat) > ChatHistoryLimit {
+		r.Chat = r.Chat[len(r.Chat)-ChatHistoryLimit:]
+	}
+
+	msgType := types.MsgChatMessage
+	if line.Kind == "emote" {
+		msgType = types.MsgEmote
+	}
+	r.Broadcast(types.NewServerMessage(msgType, types.ChatData{
+		RoomID: r.ID,
+		Line:   line,
+	}))
+	return nil
+}
+
+// flagFall is called by the clock when color runs out of time.
+func (r *Room) flagFall(color game.Player) {
+	loser := r.playerWithColor(color)
+	println("Flag fell for", loser, "in room", r.ID)
+	r.Forfeit(loser, db.TerminationTimeout)
+}
+
+//////////////////////////////////////////////
+// ClockState is the clock as sent to clients: the time control, each
+// player's remaining time in milliseconds and whose clock is running.
+//////////////////////////////////////////////
+
+func (r *Room) ClockState() *types.ClockState {
+	if r.Clock == nil {
+		return nil
+	}
+	now := time.Now()
+	remaining := make(map[string]int64, len(r.PlayerColors))
+	for username, color := range r.PlayerColors {
+		remaining[username] = r.Clock.Remaining(color, now).Milliseconds()
+	}
+	return &types.ClockState{
+		TimeControl: r.TimeControl,
+		RemainingMs: remaining,
+		Running:     r.playerWithColor(r.Clock.Running()),
+	}
+}
+
+// LatencyMs is the last measured round trip to each connected player, in
+// milliseconds. It is 0 for a player who has not answered a ping yet.
+func (r *Room) LatencyMs() map[string]int64 {
+	latency := make(map[string]int64, len(r.Players))
+	for username, conn := range r.Players {
+		if conn == nil {
+			continue
+		}
+		if _, disconnected := r.DisconnectedPlayers[username]; disconnected {
+			continue
+		}
+		latency[username] = conn.Latency().Milliseconds()
+	}
+	return latency
+}
+
+/////////////////////////////////////////////////////
+// SESSIONS
+// Every human player gets a session token when the game starts. Only the
+// player's current token lets them take their seat back after a
+// disconnect. Rejoining replaces it, and forgetting it revokes it.
+/////////////////////////////////////////////////////
+
+func (r *Room) issueSession(username string) string {
+	// A token must outlive the game, or a correspondence player who is
+	// away for a few days could not take their seat back.
+	signer := Sessions
+	if longest := r.TimeControl.MaxDuration(game.MaxMoves); longest > signer.TTL {
+		signer.TTL = longest
+	}
+	token, claims := signer.Issue(username, r.ID, time.Now())
+	if r.sessions == nil {
+		r.sessions = make(map[string]string)
+	}
+	r.sessions[username] = claims.ID
+	return token
+}
+
+// CheckSession reports why token does not let username rejoin this room,
+// or nil if it does.
+func (r *Room) CheckSession(username, token string) error {
+	claims, err := Sessions.Verify(token, time.Now())
+	if err != nil {
+		return err
+	}
+	if claims.Username != username || claims.RoomID != r.ID {
+		return ErrWrongSession
+	}
+	if current, ok := r.sessions[username]; !ok || current != claims.ID {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func (r *Room) RevokeSession(username string) {
+	delete(r.sessions, username)
+}
+
+// HoldsSeat reports whether conn is the connection seated as username. A
+// connection that merely uses the same name has to rejoin first.
+func (r *Room) HoldsSeat(username string, conn *client.Client) bool {
+	seated, ok := r.Players[username]
+	return ok && seated == conn
+}
+
+func (r *Room) Abandon(username string) {
+	println("Player abandoned room:", username)
+	r.RevokeSession(username)
+	r.DisconnectedPlayers[username] = time.Now()
+	r.PickWinner(db.TerminationAbandonment)
+}
+
+/////////////////////////////////////////////////////
+// ENDS THE GAME, RECORDS IT AND UPDATES PLAYER STATS
+// AN EMPTY WINNER WITH A DRAW TERMINATION IS A DRAW,
+// ANY OTHER EMPTY WINNER MEANS NOBODY WON
+/////////////////////////////////////////////////////
+
+func (r *Room) EndGame(winner string, termination string) {
+	if r.Status == "finished" {
+		return
+	}
+	println("Ending game in room", r.ID, "winner:", winner, "termination:", termination)
+
+	r.Status = "finished"
+	r.ended.Store(true)
+	r.sessions = nil // nobody can rejoin a finished game
+	r.Winner = winner
+	r.Draw = winner == "" && (termination == db.TerminationDraw || termination == db.TerminationDrawAgreement)
+	r.Termination = termination
+	r.EndedAt = time.Now()
+	if r.Clock != nil {
+		r.Clock.Stop(r.EndedAt)
+	}
+	if winner != "" {
+		r.Loser = r.GetOpponent(winner)
+	}
+
+	r.SaveGame()
+
+	if r.Rated && (winner != "" || r.Draw) {
+		r.UpdatePlayerStats(winner)
+	}
+}
+
+/////////////////////////////////////////////////////
+// SAVES THE FINISHED GAME AND ITS MOVES TO THE DATABASE
+/////////////////////////////////////////////////////
+
+func (r *Room) SaveGame() {
+	if r.StartedAt.IsZero() {
+		println("Game never started, not saving room", r.ID)
+		return
+	}
+
+	result := db.ResultNone
+	if r.Draw {
+		result = db.ResultDraw
+	} else if r.Winner != "" {
+		switch r.PlayerColors[r.Winner] {
+		case game.Red:
+			result = db.ResultRedWin
+		case game.Blue:
+			result = db.ResultBlueWin
+		}
+	}
+
+	record := &db.Game{
+		RoomID:       r.ID,
+		RedPlayer:    r.playerWithColor(game.Red),
+		BluePlayer:   r.playerWithColor(game.Blue),
+		OpponentType: r.OpponentType,
+		Winner:       r.Winner,
+		Result:       result,
+		Termination:  r.Termination,
+		StartedAt:    r.StartedAt,
+		EndedAt:      r.EndedAt,
+	}
+	if r.OpponentType == "bot" {
+		record.BotDifficulty = string(r.BotDifficulty)
+	}
+
+	dbInstance := roomManagerInstance.Database
+	if dbInstance == nil {
+		println("No database, not saving room", r.ID)
+		return
+	}
+
+	gameID, err := dbInstance.InsertGame(record, r.Moves)
+	if err != nil {
+		log.Printf("Failed to save game for room %s: %v", r.ID, err)
+		return
+	}
+	r.GameID = gameID
+	println("Saved game", gameID, "for room", r.ID)
+}
+
+////////////////////////////////////////////////////
+//DELETE ROOM FUNCTION
+//DELETES THE ROOM FROM THE PlayingRooms , roomIdToRoom
+//A ROOM THAT IS NOT KEPT FOR A REMATCH IS CLOSED
+////////////////////////////////////////////////////
+
+func (r *Room) DeleteRoom() {
+	println("Deleting room")
+	kept := r.Status == "finished" && !r.StartedAt.IsZero()
+	if kept {
+		r.keepForRematch()
+	}
+	r.clearInvite()
+	for spectator := range r.Spectators {
+		if roomId, _ := client.GetClientManager().GetSpectatingClient(spectator); roomId == r.ID {
+			client.GetClientManager().RemoveSpectatingClient(spectator)
+		}
+	}
+
+	roomManagerInstance.mu.Lock()
+	delete(roomManagerInstance.PlayingRooms, r.ID)
+	delete(roomManagerInstance.roomIdToRoom, r.ID)
+	roomManagerInstance.mu.Unlock()
+
+	if !kept {
+		r.close()
+	}
+}
+
+////////////////////////////////////////////////////
+// GET ROOM BY ID FUNCTION
+// RETURNS THE ROOM BY ID
+////////////////////////////////////////////////////
+
+//////////////////////////////////////////////
+// BROADCASTING
+// Broadcast sends a message to every connected seated player and every
+// spectator. Per-player messages go to Players directly and use
+// BroadcastToSpectators for the watchers.
+//////////////////////////////////////////////
+
+func (r *Room) Broadcast(msg types.SocketServerMessageType) {
+	for username, conn := range r.Players {
+		if username == "bot" || conn == nil {
+			continue
+		}
+		if err := conn.Send(msg); err != nil {
+			println("Error sending", msg.Type, "to", username, ":", err.Error())
+		}
+	}
+	r.BroadcastToSpectators(msg)
+}
+
+func (r *Room) BroadcastToSpectators(msg types.SocketServerMessageType) {
+	for username, conn := range r.Spectators {
+		if err := conn.Send(msg); err != nil {
+			println("Error sending", msg.Type, "to spectator", username, ":", err.Error())
+		}
+	}
+}
+
+//////////////////////////////////////////////
+// SPECTATORS
+//////////////////////////////////////////////
+
+// Snapshot is the full state of the room a spectator needs to start watching.
+func (r *Room) Snapshot() types.SpectateData {
+	snapshot := types.SpectateData{
+		RoomID:       r.ID,
+		Status:       r.Status,
+		OpponentType: r.OpponentType,
+		CurrentTurn:  r.CurrentTurn,
+		Players:      r.PlayerColors,
+		GridData:     r.GridData,
+		Moves:        r.Moves,
+		Spectators:   len(r.Spectators),
+		StartedAt:    r.StartedAt,
+		Clock:        r.ClockState(),
+		Chat:         r.Chat,
+		LatencyMs:    r.LatencyMs(),
+	}
+	if r.OpponentType == "bot" {
+		snapshot.BotDifficulty = r.BotDifficulty
+	}
+	return snapshot
+}
+
+func (r *Room) AddSpectator(username string, conn *client.Client) {
+	println("Spectator joining room", r.ID, ":", username)
+	r.Spectators[username] = conn
+	conn.Send(types.NewServerMessage(types.MsgSpectateStarted, r.Snapshot()))
+}
+
+func (r *Room) RemoveSpectator(username string) {
+	println("Spectator leaving room", r.ID, ":", username)
+	delete(r.Spectators, username)
+}
+
+// LiveRoom summarises a game in progress for the live games list.
+type LiveRoom struct {
+	RoomID        string                 `json:"room_id"`
+	Players       map[string]game.Player `json:"players"`
+	OpponentType  string                 `json:"opponent_type"`
+	BotDifficulty bot.Difficulty         `json:"bot_difficulty,omitempty"`
+	CurrentTurn   string                 `json:"current_turn"`
+	Ply           int                    `json:"ply"`
+	Spectators    int                    `json:"spectators"`
+	StartedAt     time.Time              `json:"started_at"`
+}
+
+//////////////////////////////////////////////
+// GetLiveRooms lists the games that can be watched, newest first.
+// Private rooms can still be watched by ID but are not listed.
+//////////////////////////////////////////////
+
+func GetLiveRooms() []LiveRoom {
+	roomManagerInstance.mu.Lock()
+	playing := make([]*Room, 0, len(roomManagerInstance.PlayingRooms))
+	for _, r := range roomManagerInstance.PlayingRooms {
+		playing = append(playing, r)
+	}
+	roomManagerInstance.mu.Unlock()
+
+	rooms := []LiveRoom{}
+	for _, r := range playing {
+		r.Do(func() {
+			if r.Status == "playing" && r.InviteCode == "" {
+				rooms = append(rooms, r.live())
+			}
+		})
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].StartedAt.After(rooms[j].StartedAt)
+	})
+	return rooms
+}
+
+func (r *Room) live() LiveRoom {
+	live := LiveRoom{
+		RoomID:       r.ID,
+		Players:      make(map[string]game.Player, len(r.PlayerColors)),
+		OpponentType: r.OpponentType,
+		CurrentTurn:  r.CurrentTurn,
+		Ply:          r.Board.Ply(),
+		Spectators:   len(r.Spectators),
+		StartedAt:    r.StartedAt,
+	}
+	for username, color := range r.PlayerColors {
+		live.Players[username] = color
+	}
+	if r.OpponentType == "bot" {
+		live.BotDifficulty = r.BotDifficulty
+	}
+	return live
+}
+
+//////////////////////////////////////////////
+// PRIVATE ROOMS
+// A private room is only found by its invite code, so matchmaking cannot
+// fill it. The second player joins with the invite code before it
+// expires, after which the room is deleted and onExpire is called.
+//////////////////////////////////////////////
+
+func CreatePrivateRoom(username string, conn *client.Client, onExpire func(r *Room)) *Room {
+	r := CreateRoom(username, conn)
+
+	roomManagerInstance.mu.Lock()
+	code := newInviteCode()
+	for roomManagerInstance.PrivateRooms[code] != nil {
+		code = newInviteCode()
+	}
+	roomManagerInstance.PrivateRooms[code] = r
+	roomManagerInstance.mu.Unlock()
+
+	r.Do(func() {
+		r.InviteCode = code
+		r.InviteExpiresAt = time.Now().Add(InviteExpiry)
+		r.inviteTimer = time.AfterFunc(InviteExpiry, func() {
+			r.Do(func() {
+				if r.Status != "waiting" || !r.hasInvite() {
+					return
+				}
+				println("Invite expired for room", r.ID)
+				r.DeleteRoom()
+				onExpire(r)
+			})
+		})
+	})
+
+	return r
+}
+
+// GetRoomByInviteCode finds a private room still waiting for its second
+// player. Codes are matched case-insensitively, ignoring spaces and dashes.
+func GetRoomByInviteCode(code string) *Room {
+	code = NormalizeInviteCode(code)
+	roomManagerInstance.mu.Lock()
+	defer roomManagerInstance.mu.Unlock()
+	return roomManagerInstance.PrivateRooms[code]
+}
+
+func NormalizeInviteCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+//////////////////////////////////////////////
+// JoinPrivate seats the invited player and starts the game. It returns
+// false if the invite was already used, cancelled or expired.
+//////////////////////////////////////////////
+
+func (r *Room) JoinPrivate(username string, conn *client.Client) bool {
+	if r.Status != "waiting" || !r.hasInvite() {
+		return false
+	}
+	r.clearInvite()
+
+	r.AddPlayer(username, conn)
+	return true
+}
+
+// hasInvite reports whether the room's invite code can still be used.
+func (r *Room) hasInvite() bool {
+	if r.InviteCode == "" {
+		return false
+	}
+	roomManagerInstance.mu.Lock()
+	defer roomManagerInstance.mu.Unlock()
+	return roomManagerInstance.PrivateRooms[r.InviteCode] == r
+}
+
+// clearInvite stops the expiry timer and frees the invite code. The code
+// stays on the room so clients can still see how it was created.
+func (r *Room) clearInvite() {
+	if r.inviteTimer != nil {
+		r.inviteTimer.Stop()
+		r.inviteTimer = nil
+	}
+	if r.InviteCode == "" {
+		return
+	}
+	roomManagerInstance.mu.Lock()
+	if roomManagerInstance.PrivateRooms[r.InviteCode] == r {
+		delete(roomManagerInstance.PrivateRooms, r.InviteCode)
+	}
+	roomManagerInstance.mu.Unlock()
+}
+
+func newInviteCode() string {
+	buf := make([]byte, inviteCodeLength)
+	rand.Read(buf)
+	for i, b := range buf {
+		buf[i] = inviteAlphabet[int(b)%len(inviteAlphabet)]
+	}
+	return string(buf)
+}
+
+//////////////////////////////////////////////
+// ReleasePlayers forgets the players' link to this room, unless they have
+// already moved on to another one.
+//////////////////////////////////////////////
+
+func (r *Room) ReleasePlayers() {
+	clientManager := client.GetClientManager()
+	for playerName := range r.PlayerColors {
+		if roomId, _ := clientManager.GetPlayingClient(playerName); roomId == r.ID {
+			clientManager.RemovePlayingClient(playerName)
+		}
+	}
+}
+
+//////////////////////////////////////////////
+// REMATCH
+// A finished room stays reachable for RematchWindow after the game ended.
+// When both players agree, a new room is created for them with colors and
+// first move swapped. The bot always accepts.
+//////////////////////////////////////////////
+
+func (r *Room) keepForRematch() {
+	roomManagerInstance.mu.Lock()
+	_, kept := roomManagerInstance.FinishedRooms[r.ID]
+	roomManagerInstance.FinishedRooms[r.ID] = r
+	roomManagerInstance.mu.Unlock()
+	if kept {
+		return
+	}
+	r.after(time.Until(r.EndedAt.Add(RematchWindow)), func() {
+		r.clearRematchOffer()
+		roomManagerInstance.mu.Lock()
+		delete(roomManagerInstance.FinishedRooms, r.ID)
+		roomManagerInstance.mu.Unlock()
+		r.close()
+	})
+}
+
+// GetFinishedRoom returns a finished room whose rematch window is still open.
+func GetFinishedRoom(id string) *Room {
+	roomManagerInstance.mu.Lock()
+	r := roomManagerInstance.FinishedRooms[id]
+	if r == nil {
+		r = roomManagerInstance.roomIdToRoom[id]
+	}
+	roomManagerInstance.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+
+	open := false
+	r.Do(func() {
+		open = r.Status == "finished" && time.Since(r.EndedAt) <= RematchWindow
+	})
+	if !open {
+		return nil
+	}
+	return r
+}
+
+// Ended reports whether the game in the room has ended. Unlike Status it
+// can be read from outside the room goroutine.
+func (r *Room) Ended() bool {
+	return r.ended.Load()
+}
+
+//////////////////////////////////////////////
+// OfferRematch records the offer and tells the opponent. If the opponent
+// already offered, or is the bot, the rematch starts straight away and the
+// new room is returned. busy reports whether a player is searching or in
+// another unfinished game, and must not wait on a room.
+//////////////////////////////////////////////
+
+func (r *Room) OfferRematch(username string, busy func(username string) bool) (*Room, error) {
+	if err := r.checkRematch(username); err != nil {
+		return nil, err
+	}
+	opponent := r.GetOpponent(username)
+
+	switch {
+	case opponent == "bot" || r.RematchOffer == opponent:
+		return r.startRematch(username, busy)
+	case r.RematchOffer == username:
+		return nil, ErrRematchOfferOpen
+	}
+	r.setRematchOffer(username)
+
+	opponentConn, connected := client.GetClientManager().GetClient(opponent)
+	if !connected {
+		return nil, ErrOpponentLeft
+	}
+	opponentConn.Send(types.NewServerMessage(types.MsgRematchOffered, types.RematchOfferedData{
+		RoomID:    r.ID,
+		Username:  username,
+		ExpiresAt: r.EndedAt.Add(RematchWindow),
+	}))
+	return nil, nil
+}
+
+func (r *Room) AcceptRematch(username string, busy func(username string) bool) (*Room, error) {
+	if err := r.checkRematch(username); err != nil {
+		return nil, err
+	}
+	offeredBy := r.RematchOffer
+	if offeredBy == "" || offeredBy == username {
+		return nil, ErrNoRematchOffer
+	}
+	return r.startRematch(username, busy)
+}
+
+// setRematchOffer records username's offer, replacing any offer they
+// have open in another room.
+func (r *Room) setRematchOffer(username string) {
+	r.RematchOffer = username
+	roomManagerInstance.mu.Lock()
+	roomManagerInstance.rematchOffers[username] = r.ID
+	roomManagerInstance.mu.Unlock()
+}
+
+func (r *Room) clearRematchOffer() {
+	if r.RematchOffer == "" {
+		return
+	}
+	roomManagerInstance.mu.Lock()
+	if roomManagerInstance.rematchOffers[r.RematchOffer] == r.ID {
+		delete(roomManagerInstance.rematchOffers, r.RematchOffer)
+	}
+	roomManagerInstance.mu.Unlock()
+	r.RematchOffer = ""
+}
+
+//////////////////////////////////////////////
+// WithdrawRematchOffer takes back username's open rematch offer, if they
+// have one, and tells their opponent. Called when the player moves on to
+// another game. It waits on the room, so rooms must not call it.
+//////////////////////////////////////////////
+
+func WithdrawRematchOffer(username string) {
+	roomManagerInstance.mu.Lock()
+	r := roomManagerInstance.FinishedRooms[roomManagerInstance.rematchOffers[username]]
+	if r == nil {
+		r = roomManagerInstance.roomIdToRoom[roomManagerInstance.rematchOffers[username]]
+	}
+	delete(roomManagerInstance.rematchOffers, username)
+	roomManagerInstance.mu.Unlock()
+	if r == nil {
+		return
+	}
+
+	r.Do(func() {
+		if r.RematchOffer != username {
+			return
+		}
+		r.RematchOffer = ""
+		println("Rematch offer withdrawn by", username, "in room", r.ID)
+		if opponentConn, connected := client.GetClientManager().GetClient(r.GetOpponent(username)); connected {
+			opponentConn.Send(types.NewServerMessage(types.MsgRematchWithdrawn, types.PlayerEventData{
+				RoomID:   r.ID,
+				Username: username,
+				Message:  "Your opponent has started another game.",
+			}))
+		}
+	})
+}
+
+func (r *Room) checkRematch(username string) error {
+	if _, seated := r.PlayerColors[username]; !seated {
+		return ErrNotSeated
+	}
+	if r.Status != "finished" || r.RematchRoomID != "" || time.Since(r.EndedAt) > RematchWindow {
+		return ErrRematchExpired
+	}
+	return nil
+}
+
+//////////////////////////////////////////////
+// startRematch seats the same players in a new room. Whoever played blue
+// moves first, and so plays red, in the rematch. It is refused while
+// either player is searching or playing somewhere else, so nobody ends up
+// seated in two games.
+//////////////////////////////////////////////
+
+func (r *Room) startRematch(username string, busy func(username string) bool) (*Room, error) {
+	first := r.playerWithColor(game.Blue)
+	second := r.playerWithColor(game.Red)
+	human, opponent := first, second
+	if human == "bot" {
+		human, opponent = second, first
+	}
+
+	for _, player := range []string{human, opponent} {
+		if player == "bot" || !busy(player) {
+			continue
+		}
+		if player == username {
+			return nil, ErrInAnotherGame
+		}
+		return nil, ErrOpponentBusy
+	}
+
+	clientManager := client.GetClientManager()
+	humanConn, connected := clientManager.GetClient(human)
+	if !connected {
+		return nil, ErrOpponentLeft
+	}
+	var opponentConn *client.Client
+	if opponent != "bot" {
+		if opponentConn, connected = clientManager.GetClient(opponent); !connected {
+			return nil, ErrOpponentLeft
+		}
+	}
+
+	if r.RematchRoomID != "" {
+		return nil, ErrRematchExpired
+	}
+	rematch := CreateRoom(human, humanConn)
+	r.RematchRoomID = rematch.ID
+	r.clearRematchOffer()
+
+	println("Rematch of room", r.ID, "in room", rematch.ID)
+	clientManager.AddPlayingClient(human, rematch.ID)
+	if opponent != "bot" {
+		clientManager.AddPlayingClient(opponent, rematch.ID)
+	}
+
+	// The new room has its own goroutine, which never waits on this one.
+	timeControl, rated, difficulty := r.TimeControl, r.Rated, r.BotDifficulty
+	rematch.Do(func() {
+		rematch.TimeControl = timeControl
+		rematch.Rated = rated
+		rematch.BotDifficulty = difficulty
+		rematch.CurrentTurn = first
+		if opponent == "bot" {
+			rematch.AddBot()
+		} else {
+			rematch.AddPlayer(opponent, opponentConn)
+		}
+	})
+	return rematch, nil
+}
+
+func GetRoomById(id string) *Room {
+	roomManagerInstance.mu.Lock()
+	defer roomManagerInstance.mu.Unlock()
+	return roomManagerInstance.roomIdToRoom[id]
+}
+
+// GetPlayingRoom returns the room if its game has started and it has not
+// been deleted yet.
+func (rm *RoomManager) GetPlayingRoom(id string) *Room {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.PlayingRooms[id]
+}
+
+////////////////////////////////////////////////////
+// CONVERT WAITING ROOM TO PLAYING ROOM
+////////////////////////////////////////////////////
+
+func (r *Room) ConverToPlaying() {
+	println("Converting room to playing")
+	r.Status = "playing"
+	r.StartedAt = time.Now()
+	roomManagerInstance.mu.Lock()
+	roomManagerInstance.PlayingRooms[r.ID] = r
+	roomManagerInstance.mu.Unlock()
+	println("Starting playing game")
+	r.StartGame()
+}
+
+///////////////////////////////////////////
+//UPDATE PLAYER STATS FUNCTION
+//UPDATES THE PLAYER STATS IN THE DATABASE
+///////////////////////////////////////////
+
+func (r *Room) UpdatePlayerStats(winner string) {
+
+	println("Updating player stats for winner:", winner)
+	dbInstance := roomManagerInstance.Database
+	if dbInstance == nil {
+		println("No database, not updating player stats")
+		return
+	}
+
+	if winner != "" {
+		var loser string
+		for playerName := range r.Players {
+			println("Player name:", playerName)
+			if playerName != winner && playerName != "bot" {
+				loser = playerName
+				break
+			}
+		}
 
 		if loser == "" {
 			for playerName := range r.DisconnectedPlayers {
@@ -728,11 +1876,14 @@ func (r *Room) UpdatePlayerStats(winner string) {
 				log.Printf("Failed to create/update loser entry: %v", err)
 			}
 
-			err = dbInstance.UpdateGameResult(winner, loser)
+			changes, err := dbInstance.UpdateGameResult(winner, loser, r.GameID)
 			if err != nil {
 				log.Printf("Failed to update game result: %v", err)
 			} else {
 				println("Successfully updated game result in database")
+				for _, change := range changes {
+					r.RatingChanges[change.Username] = change
+				}
 
 				winnerPlayer, err := dbInstance.GetPlayerByUsername(winner)
 				if err != nil {
@@ -780,11 +1931,14 @@ func (r *Room) UpdatePlayerStats(winner string) {
 				log.Printf("Failed to create/update second player entry: %v", err)
 			}
 
-			err = dbInstance.UpdateDraw(humanPlayers[0], humanPlayers[1])
+			changes, err := dbInstance.UpdateDraw(humanPlayers[0], humanPlayers[1], r.GameID)
 			if err != nil {
 				log.Printf("Failed to update draw result: %v", err)
 			} else {
 				println("Successfully updated draw result in database")
+				for _, change := range changes {
+					r.RatingChanges[change.Username] = change
+				}
 			}
 		} else {
 			println("Not enough human players for a draw update")
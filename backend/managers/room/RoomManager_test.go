@@ -0,0 +1,283 @@
+package room
+
+import (
+	"backend/managers/client"
+	"backend/session"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestRoom(t *testing.T) *Room {
+	GetRoomManager()
+	r := CreateRoom("alice", nil)
+	t.Cleanup(func() { r.Do(r.DeleteRoom) })
+	return r
+}
+
+// testClient returns a client for username whose peer reads and discards
+// everything the room sends.
+func testClient(t *testing.T, username string) *client.Client {
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- ws
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	go func() {
+		for {
+			if _, _, err := peer.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := client.NewClient(username, <-conns)
+	t.Cleanup(c.Close)
+	return c
+}
+
+// newTestGame starts a game between alice, who moves first, and bob.
+func newTestGame(t *testing.T) *Room {
+	GetRoomManager()
+	r := CreateRoom("alice", testClient(t, "alice"))
+	t.Cleanup(func() { r.Do(r.DeleteRoom) })
+	r.Do(func() { r.AddPlayer("bob", testClient(t, "bob")) })
+	return r
+}
+
+// TestDoSerializesCommands changes room state without a lock from many
+// goroutines. Under -race any command running off the room goroutine, or
+// two at once, is reported.
+func TestDoSerializesCommands(t *testing.T) {
+	rooms := make([]*Room, 20)
+	for i := range rooms {
+		rooms[i] = newTestRoom(t)
+	}
+
+	const perRoom = 200
+	var wg sync.WaitGroup
+	for _, r := range rooms {
+		for i := 0; i < perRoom; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.Do(func() { r.TotalPlayers++ })
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, r := range rooms {
+		var total int
+		r.Do(func() { total = r.TotalPlayers })
+		if total != 1+perRoom {
+			t.Errorf("room %s counted %d players, want %d", r.ID, total, 1+perRoom)
+		}
+	}
+}
+
+func TestAfterRunsOnTheRoom(t *testing.T) {
+	r := newTestRoom(t)
+	fired := make(chan string, 1)
+	r.Do(func() {
+		r.after(10*time.Millisecond, func() { fired <- r.Status })
+	})
+
+	select {
+	case status := <-fired:
+		if status != "waiting" {
+			t.Errorf("status = %q, want waiting", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the command scheduled with after never ran")
+	}
+}
+
+func TestDoAfterClose(t *testing.T) {
+	r := newTestRoom(t)
+	fired := make(chan struct{}, 1)
+	r.Do(func() {
+		r.after(10*time.Millisecond, func() { fired <- struct{}{} })
+		r.close()
+	})
+
+	if r.Do(func() { t.Error("a command ran on a closed room") }) {
+		t.Errorf("Do on a closed room = true")
+	}
+	select {
+	case <-fired:
+		t.Errorf("a timer fired on a closed room")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestCloseWhileBusy closes rooms while other goroutines are still sending
+// commands. Every Do must return, and none may run after the close.
+func TestCloseWhileBusy(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		r := newTestRoom(t)
+		closed := false
+
+		var wg sync.WaitGroup
+		for j := 0; j < 50; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				r.Do(func() {
+					if closed {
+						t.Errorf("a command ran after the room closed")
+					}
+					if j == 25 {
+						closed = true
+						r.close()
+					}
+				})
+			}(j)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Do blocked on a closed room")
+		}
+	}
+}
+
+func TestAbortUntilBothHaveMoved(t *testing.T) {
+	tests := []struct {
+		moves   int
+		aborter string
+		want    error
+	}{
+		{0, "alice", nil},
+		{0, "bob", nil},
+		{1, "alice", nil},
+		{1, "bob", nil},
+		{2, "alice", ErrAbortTooLate},
+		{2, "bob", ErrAbortTooLate},
+	}
+
+	for _, tt := range tests {
+		r := newTestGame(t)
+		var err error
+		var status string
+		r.Do(func() {
+			for i := 0; i < tt.moves; i++ {
+				r.PlayMove(3)
+			}
+			err = r.Abort(tt.aborter)
+			status = r.Status
+		})
+
+		if err != tt.want {
+			t.Errorf("%s aborting after %d moves: error = %v, want %v", tt.aborter, tt.moves, err, tt.want)
+		}
+		wantStatus := "playing"
+		if tt.want == nil {
+			wantStatus = "finished"
+		}
+		if status != wantStatus {
+			t.Errorf("%s aborting after %d moves left the game %s, want %s", tt.aborter, tt.moves, status, wantStatus)
+		}
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	r := newTestGame(t)
+	other := newTestGame(t)
+
+	var elsewhere string
+	other.Do(func() { elsewhere = other.issueSession("alice") })
+
+	type check struct {
+		name string
+		err  error
+		want error
+	}
+	var checks []check
+	r.Do(func() {
+		replaced := r.issueSession("alice")
+		current := r.issueSession("alice")
+		bobs := r.issueSession("bob")
+		r.RevokeSession("bob")
+
+		checks = []check{
+			{"current token", r.CheckSession("alice", current), nil},
+			{"replaced token", r.CheckSession("alice", replaced), ErrSessionRevoked},
+			{"revoked token", r.CheckSession("bob", bobs), ErrSessionRevoked},
+			{"another player's token", r.CheckSession("bob", current), ErrWrongSession},
+			{"another room's token", r.CheckSession("alice", elsewhere), ErrWrongSession},
+			{"forged token", r.CheckSession("alice", "garbage"), session.ErrInvalidToken},
+		}
+	})
+
+	for _, c := range checks {
+		if c.err != c.want {
+			t.Errorf("%s: CheckSession = %v, want %v", c.name, c.err, c.want)
+		}
+	}
+}
+
+func TestJoinPlayerOnlyWhenDisconnected(t *testing.T) {
+	r := newTestGame(t)
+	conn := testClient(t, "alice")
+
+	var seated, rejoined error
+	var holds bool
+	r.Do(func() {
+		token := r.issueSession("alice")
+		seated = r.JoinPlayer("alice", conn, token)
+
+		r.DisconnectedPlayers["alice"] = time.Now()
+		rejoined = r.JoinPlayer("alice", conn, token)
+		holds = r.HoldsSeat("alice", conn)
+	})
+
+	if seated != ErrNotDisconnected {
+		t.Errorf("rejoining while seated: error = %v, want %v", seated, ErrNotDisconnected)
+	}
+	if rejoined != nil || !holds {
+		t.Errorf("rejoining after a disconnect: error = %v, holds seat %v", rejoined, holds)
+	}
+}
+
+// TestSnapshotIsNotShared sends spectators snapshots while the moves they
+// list are taken back and replayed. Under -race a queued snapshot that
+// still shares the room's slices is reported.
+func TestSnapshotIsNotShared(t *testing.T) {
+	r := newTestGame(t)
+	for i := 0; i < 50; i++ {
+		spectator := testClient(t, "spectator")
+		r.Do(func() {
+			r.PlayMove(i % 7)
+			r.PlayMove(i % 7)
+			r.AddSpectator("spectator", spectator)
+			r.takeBack(2)
+			r.PlayMove((i + 1) % 7)
+			r.PlayMove((i + 1) % 7)
+			r.takeBack(2)
+		})
+	}
+}
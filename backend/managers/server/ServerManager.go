@@ -1,15 +1,26 @@
 package server
 
 import (
+	"backend/auth"
+	"backend/bot"
+	"backend/clock"
 	"backend/config"
+	"backend/db"
+	"backend/game"
 	"backend/managers/client"
+	"backend/managers/matchmaking"
 	"backend/managers/room"
 	"backend/managers/socket"
 	"backend/managers/types"
+	"backend/session"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,8 +35,22 @@ type ServerManager struct {
 	clientManager *client.ClientManager
 	roomManager   *room.RoomManager
 	socketManager *socket.SocketManager
+	matchmaker    *matchmaking.Queue
+	database      *db.DB // nil when players are not stored
 }
 
+// MaxBotAfter bounds how long a client may ask to wait before the bot joins.
+const MaxBotAfter = 120 * time.Second
+
+// MaxPendingMessages is how many messages from one connection can wait to be
+// handled before the server stops reading from it.
+const MaxPendingMessages = 32
+
+var (
+	ErrInvalidOpponent = errors.New("Invalid opponent, expected any or human")
+	ErrInvalidBotAfter = fmt.Errorf("Invalid bot_after, expected 0 to %v seconds", MaxBotAfter.Seconds())
+)
+
 var (
 	serverManager *ServerManager
 	once          sync.Once
@@ -42,6 +67,7 @@ func GetServerManager() *ServerManager {
 			roomManager:   room.GetRoomManager(),
 			socketManager: socket.GetSocketManager(),
 		}
+		serverManager.matchmaker = matchmaking.NewQueue(matchmaking.DefaultConfig, serverManager.StartMatch)
 	})
 	return serverManager
 }
@@ -55,6 +81,26 @@ func (sm *ServerManager) StartServer() {
 	if err != nil {
 		log.Fatalf("Failed to load server config: %v", err)
 	}
+	client.HeartbeatTimeout = serverConfig.HeartbeatTimeout
+
+	sessionConfig, err := config.LoadSessionConfig()
+	if err != nil {
+		log.Fatalf("Failed to load session config: %v", err)
+	}
+	if len(sessionConfig.Secret) == 0 {
+		println("SESSION_SECRET is not set, session tokens will not survive a restart")
+	}
+	room.Sessions = session.NewSigner(sessionConfig.Secret, sessionConfig.TTL)
+
+	authConfig, err := config.LoadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load auth config: %v", err)
+	}
+	if len(authConfig.Secret) == 0 {
+		println("JWT_SECRET is not set, access tokens will not survive a restart")
+	}
+	auth.Tokens = auth.NewIssuer(authConfig.Secret, authConfig.AccessTTL, authConfig.RefreshTTL)
+	sm.matchmaker.Start()
 	http.HandleFunc("/join", CheckRoomValidityHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -74,14 +120,29 @@ func (sm *ServerManager) StartServer() {
 	http.ListenAndServe(":"+port, nil)
 }
 
+// UseDatabase shares one database pool with the rooms and the rating
+// lookups of matchmaking. Call it before StartServer.
+func (sm *ServerManager) UseDatabase(database *db.DB) {
+	sm.database = database
+	sm.roomManager.Database = database
+}
+
 // //////////////////////////////////////////////
 // WEBSOCKET HANDLER
+// THE USERNAME COMES FROM THE ACCESS TOKEN, A username
+// PARAMETER IS ONLY CHECKED AGAINST IT
 // ///////////////////////////////////////////////
 func WebSocketHandler(sm *ServerManager, w http.ResponseWriter, r *http.Request) {
 	println("New connection")
-	username := r.URL.Query().Get("username")
-	if username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+	claims, err := auth.Tokens.Verify(auth.BearerToken(r), time.Now())
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	username := claims.Subject
+	if requested := r.URL.Query().Get("username"); requested != "" && requested != username {
+		http.Error(w, "Username does not match access token", http.StatusForbidden)
 		return
 	}
 	if username == "bot" {
@@ -89,10 +150,28 @@ func WebSocketHandler(sm *ServerManager, w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	requested := 0
+	if value := r.URL.Query().Get("version"); value != "" {
+		var err error
+		if requested, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid protocol version", http.StatusBadRequest)
+			return
+		}
+	}
+	version, ok := types.NegotiateVersion(requested)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported protocol version, the server speaks %d to %d", types.MinProtocolVersion, types.ProtocolVersion), http.StatusBadRequest)
+		return
+	}
+
 	if roomId, b := sm.clientManager.GetPlayingClient(username); b {
-		previousRoom, itexists := sm.roomManager.PlayingRooms[roomId]
-		if itexists && previousRoom.Status == "playing" {
-			if _, exists := previousRoom.DisconnectedPlayers[username]; !exists {
+		if previousRoom := sm.roomManager.GetPlayingRoom(roomId); previousRoom != nil {
+			inUse := false
+			previousRoom.Do(func() {
+				_, disconnected := previousRoom.DisconnectedPlayers[username]
+				inUse = previousRoom.Status == "playing" && !disconnected
+			})
+			if inUse {
 
 				log.Println("Username in use ❌")
 				http.Error(w, "Username already in use", http.StatusConflict)
@@ -101,10 +180,22 @@ func WebSocketHandler(sm *ServerManager, w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	conn, _ := sm.socketManager.Upgrade(w, r)
+	ws, err := sm.socketManager.Upgrade(w, r)
+	if err != nil {
+		println("Error upgrading connection:", err.Error())
+		return
+	}
+	conn := client.NewClient(username, ws)
 	sm.clientManager.AddClient(username, conn)
 	println("Client added for handleSocket tracking")
-	go handleSocket(sm, conn)
+	conn.Send(types.NewServerMessage(types.MsgConnectionAck, types.ConnectionAckData{
+		Username:   username,
+		Guest:      claims.Guest,
+		Version:    version,
+		MinVersion: types.MinProtocolVersion,
+		MaxVersion: types.ProtocolVersion,
+	}))
+	go handleSocket(sm, conn, version)
 
 }
 
@@ -116,6 +207,7 @@ func CheckRoomValidityHandler(w http.ResponseWriter, r *http.Request) {
 	println("Join request received")
 	username := r.URL.Query().Get("username")
 	roomId := r.URL.Query().Get("roomId")
+	inviteCode := r.URL.Query().Get("code")
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if username == "" {
@@ -123,12 +215,17 @@ func CheckRoomValidityHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if roomId == "" {
-		http.Error(w, "Room ID is required", http.StatusBadRequest)
+	if roomId == "" && inviteCode == "" {
+		http.Error(w, "Room ID or invite code is required", http.StatusBadRequest)
 		return
 	}
 
-	roomie := room.GetRoomById(roomId)
+	var roomie *room.Room
+	if inviteCode != "" {
+		roomie = room.GetRoomByInviteCode(inviteCode)
+	} else {
+		roomie = room.GetRoomById(roomId)
+	}
 	if roomie == nil {
 		println("Room not found")
 		http.Error(w, "Room not found", http.StatusNotFound)
@@ -145,272 +242,844 @@ func CheckRoomValidityHandler(w http.ResponseWriter, r *http.Request) {
 // NEW GAME HANDLER
 ////////////////////////////////////////////////
 
-func NewGameHandler(sm *ServerManager, conn *websocket.Conn, username string) {
+func NewGameHandler(sm *ServerManager, req *Request, msg types.NewGameRequest) {
+	difficulty, ok := bot.ParseDifficulty(msg.Difficulty)
+	if !ok {
+		req.Error(types.ErrCodeInvalidDifficulty, "Invalid difficulty, expected easy, medium, hard or perfect")
+		return
+	}
+
+	preference, err := parsePreference(msg)
+	if err != nil {
+		req.Fail(err)
+		return
+	}
+
+	timeControl, err := clock.ParseTimeControl(msg.TimeControl)
+	if err != nil {
+		req.Error(types.ErrCodeInvalidTimeControl, err.Error())
+		return
+	}
+
 	//////////////////////////////////////////////////////
 	//MATCHMAKING : CHECKING IF THE USER IS ALREADY IN A GAME
 	//////////////////////////////////////////////////////
 
-	if roomId, exists := sm.clientManager.GetPlayingClient(username); exists {
-		if sm.roomManager.PlayingRooms[roomId] != nil {
-			conn.WriteJSON(types.SocketServerMessageType{
-				Type: "info",
-				Data: map[string]any{
-					"info": "Previous game has been terminated",
-				},
-			})
-			sm.clientManager.RemovePlayingClient(username)
+	leavePreviousGame(sm, req)
 
-			r := room.GetRoomById(roomId)
-			r.PickWinner()
-		} else {
-			conn.WriteJSON(types.SocketServerMessageType{
-				Type: "info",
-				Data: map[string]any{
-					"info": "Previous game was closed by the server",
-				},
-			})
-		}
+	//////////////////////////////////////////////////////
+	//MATCHMAKING : JOINING THE QUEUE, THE MATCH IS STARTED
+	//BY StartMatch ONCE THE QUEUE PAIRS THE PLAYER
+	//////////////////////////////////////////////////////
 
+	ticket := matchmaking.Ticket{
+		Username:      req.Username,
+		Rating:        sm.playerRating(req.Username),
+		Preference:    preference,
+		BotDifficulty: difficulty,
+		TimeControl:   timeControl,
+	}
+	if err := sm.matchmaker.Enqueue(ticket); err != nil {
+		req.Error(types.ErrCodeAlreadySearching, "Already searching for a game")
+		return
 	}
-	var r *room.Room
 
-	//////////////////////////////////////////////////////
-	//MATCHMAKING : SEARCHING FOR A ROOM IN WAITING ROOMS
-	//////////////////////////////////////////////////////
+	req.Client.Send(types.NewServerMessage(types.MsgNewGameResponse, types.NewGameResponseData{
+		Status:        "searching",
+		Rating:        ticket.Rating,
+		HumanOnly:     preference.HumanOnly,
+		BotAfter:      preference.BotAfter.Seconds(),
+		BotDifficulty: difficulty,
+		TimeControl:   timeControl,
+	}))
+}
 
-	for roomId := range room.GetRoomManager().WaitingRooms {
-		r := room.GetRoomById(roomId)
-		r.AddPlayer(username, conn)
-		sm.clientManager.AddPlayingClient(username, r.ID)
+////////////////////////////////////////////////
+// ENDS WHATEVER GAME OR INVITE THE USER WAS IN BEFORE STARTING ANOTHER
+////////////////////////////////////////////////
+
+func leavePreviousGame(sm *ServerManager, req *Request) {
+	room.WithdrawRematchOffer(req.Username)
+
+	roomId, exists := sm.clientManager.GetPlayingClient(req.Username)
+	if !exists {
 		return
 	}
 
-	///////////////////////////////////////////////////////////////////////////
-	//MATCHMAKING :IF NOT FOUND IN WAITING ROOMS , CREATING A NEW ROOM AND WAIT
-	///////////////////////////////////////////////////////////////////////////
+	if r := sm.roomManager.GetPlayingRoom(roomId); r != nil {
+		req.Info("Previous game has been terminated")
+		sm.clientManager.RemovePlayingClient(req.Username)
 
-	r = room.CreateRoom(username, conn)
-	sm.roomManager.WaitingRooms[r.ID] = r
-	sm.clientManager.AddPlayingClient(username, r.ID)
+		r.Do(func() { r.Abandon(req.Username) })
+		return
+	}
 
-	conn.WriteJSON(types.SocketServerMessageType{
-		Type: "new_game_response",
-		Data: map[string]any{
-			"room_id":       r.ID,
-			"status":        "waiting",
-			"current_turn":  r.CurrentTurn,
-			"total_players": r.TotalPlayers,
-			"players":       r.Players,
-			"grid_data":     r.GridData,
-		},
-	})
+	cancelled := false
+	if r := room.GetRoomById(roomId); r != nil {
+		r.Do(func() {
+			if r.InviteCode != "" && r.Status == "waiting" {
+				r.DeleteRoom()
+				cancelled = true
+			}
+		})
+	}
+	if cancelled {
+		req.Info("Previous private game invite has been cancelled")
+		sm.clientManager.RemovePlayingClient(req.Username)
+	} else {
+		req.Info("Previous game was closed by the server")
+	}
+}
 
-	/////////////////////////////
-	// TIMER FOR BOT JOINING
-	/////////////////////////////
+////////////////////////////////////////////////
+// PARSES THE OPPONENT PREFERENCE OF A NEW GAME
+// opponent: "any" (default) or "human"
+// bot_after: seconds to wait before the bot joins
+////////////////////////////////////////////////
 
-	go func() {
-		time.Sleep(10 * time.Second)
-		if r.Status == "playing" || r.TotalPlayers == room.PlayersNeeded {
-			return
+func parsePreference(req types.NewGameRequest) (matchmaking.Preference, error) {
+	preference := matchmaking.DefaultPreference
+
+	switch req.Opponent {
+	case "", "any":
+	case "human":
+		preference.HumanOnly = true
+	default:
+		return preference, ErrInvalidOpponent
+	}
+
+	if req.BotAfter != nil && !preference.HumanOnly {
+		seconds := *req.BotAfter
+		if seconds < 0 || time.Duration(seconds*float64(time.Second)) > MaxBotAfter {
+			return preference, ErrInvalidBotAfter
 		}
-		if sm.roomManager.WaitingRooms[r.ID] == nil {
-			println("Room not found in waiting rooms")
+		preference.BotAfter = time.Duration(seconds * float64(time.Second))
+	}
+
+	return preference, nil
+}
+
+////////////////////////////////////////////////
+// LOOKS UP THE RATING USED FOR MATCHMAKING
+////////////////////////////////////////////////
+
+func (sm *ServerManager) playerRating(username string) int {
+	if sm.database == nil {
+		return matchmaking.DefaultRating
+	}
+
+	player, err := sm.database.GetPlayerByUsername(username)
+	if err != nil {
+		return matchmaking.DefaultRating
+	}
+	return player.Rating
+}
+
+////////////////////////////////////////////////
+// START MATCH
+// Called by the matchmaking queue with a pair of players, or a single
+// player for the bot. The player who waited longest moves first.
+////////////////////////////////////////////////
+
+func (sm *ServerManager) StartMatch(m matchmaking.Match) {
+	conn, connected := sm.clientManager.GetClient(m.First.Username)
+
+	if m.Opponent == nil {
+		if !connected {
+			println("Matched player left before the bot joined:", m.First.Username)
 			return
 		}
-		r.AddBot()
-	}()
+		r := room.CreateRoom(m.First.Username, conn)
+		sm.clientManager.AddPlayingClient(m.First.Username, r.ID)
+		r.Do(func() {
+			r.BotDifficulty = m.First.BotDifficulty
+			r.TimeControl = m.First.TimeControl
+			r.AddBot()
+		})
+		return
+	}
+
+	opponentConn, opponentConnected := sm.clientManager.GetClient(m.Opponent.Username)
+	if !connected || !opponentConnected {
+		// Whoever is still here goes back in the queue without losing their place.
+		if connected {
+			sm.matchmaker.Enqueue(m.First)
+		}
+		if opponentConnected {
+			sm.matchmaker.Enqueue(*m.Opponent)
+		}
+		return
+	}
 
+	println("Matched", m.First.Username, "(", m.First.Rating, ") with", m.Opponent.Username, "(", m.Opponent.Rating, ")")
+	r := room.CreateRoom(m.First.Username, conn)
+	sm.clientManager.AddPlayingClient(m.First.Username, r.ID)
+	sm.clientManager.AddPlayingClient(m.Opponent.Username, r.ID)
+	r.Do(func() {
+		r.BotDifficulty = m.First.BotDifficulty
+		r.TimeControl = m.First.TimeControl
+		r.Rated = true
+		r.AddPlayer(m.Opponent.Username, opponentConn)
+	})
 }
 
 ////////////////////////////////////////////////
-// GAME UPDATE HANDLER
-// Handles game updates like placing discs
+// CANCEL SEARCH HANDLER
+// Takes the player out of the matchmaking queue
 ////////////////////////////////////////////////
 
-func GameUpdateHandler(sm *ServerManager, conn *websocket.Conn, username string, data map[string]any) {
-	// Get room ID from the message
-	roomId, ok := data["room_id"].(string)
-	if !ok {
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "error",
-			Data: map[string]any{
-				"error": "Invalid room ID",
-			},
-		})
+func CancelSearchHandler(sm *ServerManager, req *Request) {
+	if !sm.matchmaker.Cancel(req.Username) {
+		req.Error(types.ErrCodeNotSearching, "Not searching for a game")
 		return
 	}
 
-	r := room.GetRoomById(roomId)
+	req.Info("Search cancelled")
+}
+
+////////////////////////////////////////////////
+// CREATE PRIVATE GAME HANDLER
+// Creates a room that matchmaking skips and returns its invite code
+////////////////////////////////////////////////
+
+func CreatePrivateGameHandler(sm *ServerManager, req *Request, msg types.CreatePrivateGameRequest) {
+	timeControl, err := clock.ParseTimeControl(msg.TimeControl)
+	if err != nil {
+		req.Error(types.ErrCodeInvalidTimeControl, err.Error())
+		return
+	}
+
+	sm.matchmaker.Cancel(req.Username)
+	leavePreviousGame(sm, req)
+
+	r := room.CreatePrivateRoom(req.Username, req.Client, func(r *room.Room) {
+		if roomId, _ := sm.clientManager.GetPlayingClient(req.Username); roomId == r.ID {
+			sm.clientManager.RemovePlayingClient(req.Username)
+		}
+		req.Client.Send(types.NewServerMessage(types.MsgPrivateGameExpired, types.PrivateGameData{
+			RoomID:     r.ID,
+			InviteCode: r.InviteCode,
+			Message:    "Nobody joined with your invite code in time.",
+		}))
+	})
+	sm.clientManager.AddPlayingClient(req.Username, r.ID)
+
+	r.Do(func() {
+		r.TimeControl = timeControl
+		r.Rated = msg.Rated
+
+		expiresAt := r.InviteExpiresAt
+		req.Client.Send(types.NewServerMessage(types.MsgPrivateGameCreated, types.PrivateGameData{
+			RoomID:      r.ID,
+			InviteCode:  r.InviteCode,
+			ExpiresAt:   &expiresAt,
+			Status:      r.Status,
+			TimeControl: &timeControl,
+			Rated:       r.Rated,
+		}))
+	})
+}
+
+////////////////////////////////////////////////
+// JOIN PRIVATE GAME HANDLER
+// Seats the second player of a private room by invite code
+////////////////////////////////////////////////
+
+func JoinPrivateGameHandler(sm *ServerManager, req *Request, msg types.JoinPrivateGameRequest) {
+	r := room.GetRoomByInviteCode(msg.InviteCode)
 	if r == nil {
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "error",
-			Data: map[string]any{
-				"error": "Room not found",
-			},
-		})
+		req.Error(types.ErrCodeInviteNotFound, "Invite code not found or expired")
+		return
+	}
+
+	own := false
+	r.Do(func() { _, own = r.Players[req.Username] })
+	if own {
+		req.Error(types.ErrCodeOwnInvite, "You cannot join your own private game")
 		return
 	}
 
-	if r.CurrentTurn != username {
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "error",
-			Data: map[string]any{
-				"error": "Not your turn",
-			},
+	sm.matchmaker.Cancel(req.Username)
+	leavePreviousGame(sm, req)
+
+	sm.clientManager.AddPlayingClient(req.Username, r.ID)
+	joined := false
+	r.Do(func() { joined = r.JoinPrivate(req.Username, req.Client) })
+	if !joined {
+		sm.clientManager.RemovePlayingClient(req.Username)
+		req.Error(types.ErrCodeInviteNotFound, "Invite code not found or expired")
+	}
+}
+
+////////////////////////////////////////////////
+// CANCEL PRIVATE GAME HANDLER
+// Lets the creator withdraw an invite nobody has used yet
+////////////////////////////////////////////////
+
+func CancelPrivateGameHandler(sm *ServerManager, req *Request) {
+	roomId, exists := sm.clientManager.GetPlayingClient(req.Username)
+	r := room.GetRoomById(roomId)
+	cancelled := false
+	if exists && r != nil {
+		r.Do(func() {
+			if r.InviteCode == "" || r.Status != "waiting" {
+				return
+			}
+			r.DeleteRoom()
+			cancelled = true
+
+			req.Client.Send(types.NewServerMessage(types.MsgPrivateGameCancelled, types.PrivateGameData{
+				RoomID:     r.ID,
+				InviteCode: r.InviteCode,
+			}))
 		})
+	}
+	if !cancelled {
+		req.Error(types.ErrCodeNoInvite, "No private game invite to cancel")
 		return
 	}
 
-	action, ok := data["action"].(string)
-	if !ok {
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "error",
-			Data: map[string]any{
-				"error": "Invalid action",
-			},
+	sm.clientManager.RemovePlayingClient(req.Username)
+}
+
+////////////////////////////////////////////////
+// SPECTATE HANDLER
+// Lets any connected user watch a game in progress
+////////////////////////////////////////////////
+
+func SpectateHandler(sm *ServerManager, req *Request, msg types.RoomRequest) {
+	r := room.GetRoomById(msg.RoomID)
+	playing, seated := false, false
+	if r != nil {
+		r.Do(func() {
+			playing = r.Status == "playing"
+			_, seated = r.Players[req.Username]
 		})
+	}
+	if !playing {
+		req.Error(types.ErrCodeNoGameInProgress, "No game in progress in this room")
+		return
+	}
+
+	if seated {
+		req.Error(types.ErrCodePlayingInRoom, "You are playing in this room")
+		return
+	}
+
+	stopSpectating(sm, req.Username)
+	sm.clientManager.AddSpectatingClient(req.Username, r.ID)
+	if !r.Do(func() { r.AddSpectator(req.Username, req.Client) }) {
+		sm.clientManager.RemoveSpectatingClient(req.Username)
+		req.Error(types.ErrCodeNoGameInProgress, "No game in progress in this room")
+	}
+}
+
+func StopSpectatingHandler(sm *ServerManager, req *Request) {
+	if !stopSpectating(sm, req.Username) {
+		req.Error(types.ErrCodeNotSpectating, "You are not watching a game")
+		return
+	}
+
+	req.Client.Send(types.NewServerMessage(types.MsgSpectateStopped, types.EmptyData{}))
+}
+
+// stopSpectating removes the user from the room they are watching, if any.
+func stopSpectating(sm *ServerManager, username string) bool {
+	roomId, exists := sm.clientManager.GetSpectatingClient(username)
+	if !exists {
+		return false
+	}
+	sm.clientManager.RemoveSpectatingClient(username)
+	if r := room.GetRoomById(roomId); r != nil {
+		r.Do(func() { r.RemoveSpectator(username) })
+	}
+	return true
+}
+
+////////////////////////////////////////////////
+// GAME UPDATE HANDLER
+// Handles game updates like placing discs
+////////////////////////////////////////////////
+
+func GameUpdateHandler(sm *ServerManager, req *Request, msg types.GameUpdateRequest) {
+	if msg.RoomID == "" {
+		req.Error(types.ErrCodeInvalidRoomID, "Invalid room ID")
+		return
+	}
+
+	r := room.GetRoomById(msg.RoomID)
+	if r == nil {
+		req.Error(types.ErrCodeRoomNotFound, "Room not found")
+		return
+	}
+
+	if connUsername, exists := sm.clientManager.GetConnectionToUsername(req.Client); !exists || connUsername != req.Username {
+		req.Error(types.ErrCodeUsernameMismatch, "Username does not match connection")
+		return
+	}
+
+	if !r.Do(func() { gameUpdate(req, r, msg) }) {
+		req.Error(types.ErrCodeRoomNotFound, "Room not found")
+	}
+}
+
+////////////////////////////////////////////////
+// CARRIES OUT A GAME UPDATE ON THE ROOM GOROUTINE
+////////////////////////////////////////////////
+
+func gameUpdate(req *Request, r *room.Room, msg types.GameUpdateRequest) {
+	action := msg.Action
+	if !r.HoldsSeat(req.Username, req.Client) {
+		sendActionError(req, r, action, room.ErrNotSeated)
 		return
 	}
 
 	switch action {
-	case "place_disc":
-		column, okCol := data["column"].(float64)
-		row, okRow := data["row"].(float64)
-		playerColor, okColor := data["player_color"].(string)
-
-		if !okCol || !okRow || !okColor {
-			conn.WriteJSON(types.SocketServerMessageType{
-				Type: "error",
-				Data: map[string]any{
-					"error": "Invalid column or row or color",
-				},
-			})
+	case types.ActionResign:
+		if err := r.Resign(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionOfferDraw:
+		if err := r.OfferDraw(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionAcceptDraw:
+		if err := r.AcceptDraw(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionDeclineDraw:
+		if err := r.DeclineDraw(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionAbort:
+		if err := r.Abort(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionTakebackRequest:
+		if err := r.RequestTakeback(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionTakebackAccept:
+		if err := r.AcceptTakeback(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionTakebackDecline:
+		if err := r.DeclineTakeback(req.Username); err != nil {
+			sendActionError(req, r, action, err)
+		}
+	case types.ActionPlaceDisc:
+		if r.Status != "playing" {
+			sendMoveError(req, r, types.ErrCodeGameNotInProgress, "Game is not in progress", nil)
 			return
 		}
 
-		r.GridData[int(column)][int(row)] = playerColor
-
-		for playerName := range r.Players {
-			if playerName != username {
-				r.CurrentTurn = playerName
-				break
-			}
+		if r.CurrentTurn != req.Username {
+			sendMoveError(req, r, types.ErrCodeNotYourTurn, "Not your turn", msg.Column)
+			return
 		}
 
-		winner := checkForWin(r.GridData, playerColor)
+		////////////////////////////////////////////////
+		// ONLY THE COLUMN IS TAKEN FROM THE CLIENT,
+		// ROW AND COLOR ARE WORKED OUT BY THE SERVER
+		////////////////////////////////////////////////
+
+		if msg.Column == nil || *msg.Column != math.Trunc(*msg.Column) {
+			sendMoveError(req, r, types.ErrCodeInvalidColumn, "Invalid column", msg.Column)
+			return
+		}
+		column := int(*msg.Column)
 
-		if winner != "" {
-			println("Game won by", username, "with color", playerColor)
-			r.Status = "finished"
-			r.Winner = username
+		playerColor, okColor := r.PlayerColors[req.Username]
+		if !okColor {
+			sendMoveError(req, r, types.ErrCodeNotSeated, "You are not seated in this room", msg.Column)
+			return
+		}
+		if r.Board.Turn() != playerColor {
+			sendMoveError(req, r, types.ErrCodeNotYourTurn, "Not your turn", msg.Column)
+			return
+		}
+		if msg.PlayerColor != "" && msg.PlayerColor != playerColor.String() {
+			sendMoveError(req, r, types.ErrCodeColorMismatch, "Player color does not match your seat", msg.Column)
+			return
+		}
 
-			if username != "bot" {
-				println("Calling UpdatePlayerStats for winner:", username)
-				r.UpdatePlayerStats(username)
-			} else {
-				println("Bot won, not updating player stats")
+		if _, err := r.PlayMove(column); err != nil {
+			switch err {
+			case game.ErrColumnFull:
+				sendMoveError(req, r, types.ErrCodeColumnFull, "Column is full", msg.Column)
+			case game.ErrColumnOutOfRange:
+				sendMoveError(req, r, types.ErrCodeColumnOutOfRange, "Column out of range", msg.Column)
+			case clock.ErrFlagFall:
+				sendMoveError(req, r, types.ErrCodeTimeExpired, "Your time has run out", msg.Column)
+			default:
+				sendMoveError(req, r, types.ErrCodeInvalidMove, "Invalid move", msg.Column)
 			}
+			return
 		}
 
-		// Notify all players about the update
-		for playerName, playerConn := range r.Players {
-			if playerName == "bot" {
-				// Handle bot logic if needed
-				continue
+		for playerName := range r.Players {
+			if playerName != req.Username {
+				r.CurrentTurn = playerName
+				break
 			}
+		}
 
-			updateMsg := types.SocketServerMessageType{
-				Type: "game_update",
-				Data: map[string]any{
-					"room_id":      r.ID,
-					"status":       r.Status,
-					"current_turn": r.CurrentTurn,
-					"grid_data":    r.GridData,
-				},
-			}
+		if r.Board.Winner() == playerColor {
+			println("Game won by", req.Username, "with color", playerColor.String())
+			r.EndGame(req.Username, db.TerminationConnectFour)
+		} else if r.Board.IsDraw() {
+			println("Game drawn in room", r.ID)
+			r.EndGame("", db.TerminationDraw)
+		}
 
-			if r.Status == "finished" {
-				updateMsg.Data["winner"] = r.Winner
-			}
+		// Notify all players and spectators about the update
+		update := types.GameUpdateData{
+			RoomID:      r.ID,
+			Status:      r.Status,
+			CurrentTurn: r.CurrentTurn,
+			GridData:    r.GridData,
+			Clock:       r.ClockState(),
+			LatencyMs:   r.LatencyMs(),
+		}
 
-			err := playerConn.WriteJSON(updateMsg)
-			if err != nil {
-				println("Error sending game update to", playerName, ":", err.Error())
+		if r.Status == "finished" {
+			update.Winner = r.Winner
+			update.Draw = r.Draw
+			update.Termination = r.Termination
+			update.RatingChanges = r.RatingChanges
+			if r.Draw {
+				update.Message = "The game ended in a draw."
 			}
 		}
 
+		r.Broadcast(types.NewServerMessage(types.MsgGameUpdate, update))
+
 		if r.Status == "finished" {
-			go func() {
-				time.Sleep(5 * time.Second)
-				for playerName := range r.Players {
-					sm.clientManager.RemovePlayingClient(playerName)
-				}
-				r.DeleteRoom()
-			}()
+			time.AfterFunc(5*time.Second, func() {
+				r.Do(func() {
+					r.ReleasePlayers()
+					r.DeleteRoom()
+				})
+			})
 		} else if r.OpponentType == "bot" && r.CurrentTurn == "bot" {
-			go r.MakeBotMove()
+			r.MakeBotMove()
 		}
+	default:
+		req.Error(types.ErrCodeInvalidAction, "Invalid action")
 	}
 }
 
 ////////////////////////////////////////////////
-// CHECK FOR WIN CONDITION COPY PASTED FROM ROOM MANAGER
+// SENDS A REJECTED MOVE BACK TO THE PLAYER
 ////////////////////////////////////////////////
 
-func checkForWin(grid [][]string, color string) string {
-	for col := 0; col < len(grid); col++ {
-		for row := 0; row < len(grid[col])-3; row++ {
-			if grid[col][row] == color &&
-				grid[col][row+1] == color &&
-				grid[col][row+2] == color &&
-				grid[col][row+3] == color {
-				return color
-			}
+func sendMoveError(req *Request, r *room.Room, code types.ErrorCode, reason string, column *float64) {
+	req.Reject(types.ErrorData{
+		Code:        code,
+		Error:       reason,
+		Action:      types.ActionPlaceDisc,
+		RoomID:      r.ID,
+		CurrentTurn: r.CurrentTurn,
+		GridData:    r.GridData,
+		Column:      column,
+	})
+}
+
+////////////////////////////////////////////////
+// SENDS A REJECTED RESIGN, DRAW OR ABORT BACK TO THE PLAYER
+////////////////////////////////////////////////
+
+func sendActionError(req *Request, r *room.Room, action string, err error) {
+	reason := err.Error()
+	req.Reject(types.ErrorData{
+		Code:   errorCode(err),
+		Error:  strings.ToUpper(reason[:1]) + reason[1:],
+		Action: action,
+		RoomID: r.ID,
+		Status: r.Status,
+	})
+}
+
+////////////////////////////////////////////////
+// REMATCH HANDLERS
+// Handle rematch_offer and rematch_accept for a recently finished room
+////////////////////////////////////////////////
+
+func RematchOfferHandler(sm *ServerManager, req *Request, msg types.RoomRequest) {
+	rematch(sm, req, types.MsgRematchOffer, msg.RoomID)
+}
+
+func RematchAcceptHandler(sm *ServerManager, req *Request, msg types.RoomRequest) {
+	rematch(sm, req, types.MsgRematchAccept, msg.RoomID)
+}
+
+func rematch(sm *ServerManager, req *Request, msgType string, roomId string) {
+	r := room.GetFinishedRoom(roomId)
+	if r == nil {
+		req.Error(types.ErrCodeNoFinishedGame, "No finished game to rematch in this room")
+		return
+	}
+
+	if connUsername, exists := sm.clientManager.GetConnectionToUsername(req.Client); !exists || connUsername != req.Username {
+		req.Error(types.ErrCodeUsernameMismatch, "Username does not match connection")
+		return
+	}
+
+	if currentRoomId, playing := sm.clientManager.GetPlayingClient(req.Username); playing && currentRoomId != r.ID {
+		inAnotherGame := false
+		if current := room.GetRoomById(currentRoomId); current != nil {
+			current.Do(func() { inAnotherGame = current.Status != "finished" })
+		}
+		if inAnotherGame {
+			r.Do(func() { sendActionError(req, r, msgType, room.ErrInAnotherGame) })
+			return
 		}
 	}
+	sm.matchmaker.Cancel(req.Username)
 
-	for col := 0; col < len(grid)-3; col++ {
-		for row := 0; row < len(grid[col]); row++ {
-			if grid[col][row] == color &&
-				grid[col+1][row] == color &&
-				grid[col+2][row] == color &&
-				grid[col+3][row] == color {
-				return color
-			}
+	done := r.Do(func() {
+		var err error
+		if msgType == types.MsgRematchAccept {
+			_, err = r.AcceptRematch(req.Username, sm.busyElsewhere(r.ID))
+		} else {
+			_, err = r.OfferRematch(req.Username, sm.busyElsewhere(r.ID))
+		}
+		if err != nil {
+			sendActionError(req, r, msgType, err)
 		}
+	})
+	if !done {
+		req.Error(types.ErrCodeNoFinishedGame, "No finished game to rematch in this room")
 	}
+}
 
-	for col := 0; col < len(grid)-3; col++ {
-		for row := 0; row < len(grid[col])-3; row++ {
-			if grid[col][row] == color &&
-				grid[col+1][row+1] == color &&
-				grid[col+2][row+2] == color &&
-				grid[col+3][row+3] == color {
-				return color
-			}
+// busyElsewhere returns a check for whether a player is searching for a
+// game or seated in an unfinished game outside roomId. The check never
+// waits on a room, so the room can run it.
+func (sm *ServerManager) busyElsewhere(roomId string) func(username string) bool {
+	return func(username string) bool {
+		if sm.matchmaker.Contains(username) {
+			return true
 		}
+		currentRoomId, playing := sm.clientManager.GetPlayingClient(username)
+		if !playing || currentRoomId == roomId {
+			return false
+		}
+		current := room.GetRoomById(currentRoomId)
+		return current != nil && !current.Ended()
 	}
+}
 
-	for col := 0; col < len(grid)-3; col++ {
-		for row := 3; row < len(grid[col]); row++ {
-			if grid[col][row] == color &&
-				grid[col+1][row-1] == color &&
-				grid[col+2][row-2] == color &&
-				grid[col+3][row-3] == color {
-				return color
-			}
+////////////////////////////////////////////////
+// CHAT HANDLERS
+// Handle chat_message and emote for the room in the request
+////////////////////////////////////////////////
+
+func ChatHandler(sm *ServerManager, req *Request, msg types.ChatRequest) {
+	if r := chatRoom(sm, req, msg.RoomID); r != nil {
+		chat(req, r, func() error { return r.SendChat(req.Username, msg.Text) })
+	}
+}
+
+func EmoteHandler(sm *ServerManager, req *Request, msg types.EmoteRequest) {
+	if r := chatRoom(sm, req, msg.RoomID); r != nil {
+		chat(req, r, func() error { return r.SendEmote(req.Username, msg.Emote) })
+	}
+}
+
+// chat sends a line on the room goroutine and answers with its error, if any.
+func chat(req *Request, r *room.Room, send func() error) {
+	done := r.Do(func() {
+		if err := send(); err != nil {
+			sendActionError(req, r, req.Type, err)
 		}
+	})
+	if !done {
+		req.Error(types.ErrCodeRoomNotFound, "Room not found")
 	}
+}
 
-	return ""
+// chatRoom finds the room to chat in, answering with an error if there is none.
+func chatRoom(sm *ServerManager, req *Request, roomId string) *room.Room {
+	r := room.GetRoomById(roomId)
+	if r == nil {
+		r = room.GetFinishedRoom(roomId)
+	}
+	if r == nil {
+		req.Error(types.ErrCodeRoomNotFound, "Room not found")
+		return nil
+	}
+
+	if connUsername, exists := sm.clientManager.GetConnectionToUsername(req.Client); !exists || connUsername != req.Username {
+		req.Error(types.ErrCodeUsernameMismatch, "Username does not match connection")
+		return nil
+	}
+	return r
+}
+
+////////////////////////////////////////////////
+// PING HANDLER
+// Lets browsers, which cannot see websocket pings, measure their latency
+////////////////////////////////////////////////
+
+func PingHandler(sm *ServerManager, req *Request, msg types.PingRequest) {
+	req.Client.Send(types.NewServerMessage(types.MsgPong, types.PongData{
+		SentAt:     msg.SentAt,
+		ServerTime: time.Now(),
+		LatencyMs:  req.Client.Latency().Milliseconds(),
+	}))
+}
+
+////////////////////////////////////////////////
+// REQUESTS
+// A Request is one client message being handled. It is answered exactly
+// once: by the first error sent through it, or by an ack once its handler
+// returns. Both carry its request_id so the client can tell which message
+// succeeded or failed. Requests without an ID are not acked.
+////////////////////////////////////////////////
+
+type Request struct {
+	ID       string
+	Type     string
+	Username string
+	Client   *client.Client
+	answered bool
+}
+
+func (req *Request) Error(code types.ErrorCode, reason string) {
+	req.Reject(types.ErrorData{Code: code, Error: reason})
+}
+
+// Ack reports that the request was carried out, unless it was already answered.
+func (req *Request) Ack() {
+	if req.answered || req.ID == "" {
+		return
+	}
+	req.answered = true
+	req.Client.Send(types.NewServerMessage(types.MsgAck, types.AckData{
+		RequestID: req.ID,
+		Type:      req.Type,
+	}))
+}
+
+// Fail rejects the request with a room, game or server error.
+func (req *Request) Fail(err error) {
+	req.Error(errorCode(err), err.Error())
+}
+
+// Reject sends a fully described error.
+func (req *Request) Reject(data types.ErrorData) {
+	if req.answered {
+		println("Dropping second answer to", req.Type, "request", req.ID, ":", data.Error)
+		return
+	}
+	req.answered = true
+	data.RequestID = req.ID
+	req.Client.Send(types.NewServerMessage(types.MsgError, data))
+}
+
+func (req *Request) Info(info string) {
+	req.Client.Send(types.NewServerMessage(types.MsgInfo, types.InfoData{
+		Info: info,
+	}))
+}
+
+// errorCodes maps the errors handlers can get back from the room, game and
+// clock packages to the code sent to the client.
+var errorCodes = map[error]types.ErrorCode{
+	room.ErrGameNotInProgress: types.ErrCodeGameNotInProgress,
+	room.ErrNotSeated:         types.ErrCodeNotSeated,
+	room.ErrNoDrawOffer:       types.ErrCodeNoDrawOffer,
+	room.ErrDrawOfferOpen:     types.ErrCodeDrawOfferOpen,
+	room.ErrBotDeclinesDraw:   types.ErrCodeBotDeclinesDraw,
+	room.ErrAbortTooLate:      types.ErrCodeAbortTooLate,
+	room.ErrRematchExpired:    types.ErrCodeRematchExpired,
+	room.ErrRematchOfferOpen:  types.ErrCodeRematchOfferOpen,
+	room.ErrNoRematchOffer:    types.ErrCodeNoRematchOffer,
+	room.ErrOpponentLeft:      types.ErrCodeOpponentLeft,
+	room.ErrOpponentBusy:      types.ErrCodeOpponentBusy,
+	room.ErrInAnotherGame:     types.ErrCodeInAnotherGame,
+	room.ErrTakebacksDisabled: types.ErrCodeTakebacksDisabled,
+	room.ErrNothingToTakeBack: types.ErrCodeNothingToTakeBack,
+	room.ErrTakebackLimit:     types.ErrCodeTakebackLimit,
+	room.ErrTakebackOfferOpen: types.ErrCodeTakebackOfferOpen,
+	room.ErrNoTakebackOffer:   types.ErrCodeNoTakebackOffer,
+	room.ErrChatEmpty:         types.ErrCodeChatEmpty,
+	room.ErrChatTooLong:       types.ErrCodeChatTooLong,
+	room.ErrUnknownEmote:      types.ErrCodeUnknownEmote,
+	room.ErrChatRateLimited:   types.ErrCodeRateLimited,
+	room.ErrChatClosed:        types.ErrCodeChatClosed,
+	room.ErrNotDisconnected:   types.ErrCodeNotDisconnected,
+	room.ErrRejoinExpired:     types.ErrCodeRejoinExpired,
+	room.ErrWrongSession:      types.ErrCodeInvalidSession,
+	room.ErrSessionRevoked:    types.ErrCodeSessionRevoked,
+	session.ErrInvalidToken:   types.ErrCodeInvalidSession,
+	session.ErrTokenExpired:   types.ErrCodeSessionExpired,
+	game.ErrColumnFull:        types.ErrCodeColumnFull,
+	game.ErrColumnOutOfRange:  types.ErrCodeColumnOutOfRange,
+	clock.ErrFlagFall:         types.ErrCodeTimeExpired,
+	ErrInvalidOpponent:        types.ErrCodeInvalidOpponent,
+	ErrInvalidBotAfter:        types.ErrCodeInvalidBotAfter,
+}
+
+func errorCode(err error) types.ErrorCode {
+	for target, code := range errorCodes {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return types.ErrCodeInternal
+}
+
+////////////////////////////////////////////////
+// DISPATCH TABLE
+// Maps every client message type to its handler. on decodes the message
+// data into the handler's request struct before running it. Handlers run
+// on the connection's message worker and must not block for long.
+////////////////////////////////////////////////
+
+type messageHandler func(sm *ServerManager, req *Request, data json.RawMessage) error
+
+func on[T any](handle func(sm *ServerManager, req *Request, msg T)) messageHandler {
+	return func(sm *ServerManager, req *Request, data json.RawMessage) error {
+		var msg T
+		if err := types.DecodeData(data, &msg); err != nil {
+			return err
+		}
+		handle(sm, req, msg)
+		return nil
+	}
+}
+
+// onEmpty is on for message types that carry no data.
+func onEmpty(handle func(sm *ServerManager, req *Request)) messageHandler {
+	return on(func(sm *ServerManager, req *Request, _ types.EmptyRequest) {
+		handle(sm, req)
+	})
+}
+
+var messageHandlers = map[string]messageHandler{
+	types.MsgNewGame:           on(NewGameHandler),
+	types.MsgGameUpdate:        on(GameUpdateHandler),
+	types.MsgReconnect:         on(ReconnectHandler),
+	types.MsgCancelSearch:      onEmpty(CancelSearchHandler),
+	types.MsgCreatePrivateGame: on(CreatePrivateGameHandler),
+	types.MsgJoinPrivateGame:   on(JoinPrivateGameHandler),
+	types.MsgCancelPrivateGame: onEmpty(CancelPrivateGameHandler),
+	types.MsgSpectate:          on(SpectateHandler),
+	types.MsgStopSpectating:    onEmpty(StopSpectatingHandler),
+	types.MsgRematchOffer:      on(RematchOfferHandler),
+	types.MsgRematchAccept:     on(RematchAcceptHandler),
+	types.MsgChatMessage:       on(ChatHandler),
+	types.MsgEmote:             on(EmoteHandler),
+	types.MsgPing:              on(PingHandler),
 }
 
 ////////////////////////////////////////////////
 // SOCKET HANDLER , HANDLES ALL SOCKET MESSAGES
 ////////////////////////////////////////////////
 
-func handleSocket(sm *ServerManager, conn *websocket.Conn) {
+func handleSocket(sm *ServerManager, conn *client.Client, version int) {
 	defer func() {
 		username, exists := sm.clientManager.GetConnectionToUsername(conn)
 		if !exists {
@@ -420,12 +1089,20 @@ func handleSocket(sm *ServerManager, conn *websocket.Conn) {
 			return
 		}
 
+		sm.matchmaker.Cancel(username)
+		stopSpectating(sm, username)
+
 		if roomId, exists := sm.clientManager.GetPlayingClient(username); exists {
 			println("Client disconnection starting for ", username, " in the room ", roomId)
 
 			r := room.GetRoomById(roomId)
 			if r != nil {
-				r.DisconnectPlayer(username)
+				r.Do(func() {
+					// Only the seated connection leaving counts as a disconnect
+					if r.HoldsSeat(username, conn) {
+						r.DisconnectPlayer(username)
+					}
+				})
 			}
 		}
 
@@ -433,6 +1110,21 @@ func handleSocket(sm *ServerManager, conn *websocket.Conn) {
 		conn.Close()
 	}()
 
+	// Messages are handled one at a time, in the order they arrived, by a
+	// single worker so a later message never overtakes an earlier one.
+	queue := make(chan []byte, MaxPendingMessages)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range queue {
+			handleMessage(sm, conn, version, msg)
+		}
+	}()
+	defer func() {
+		close(queue)
+		<-done
+	}()
+
 	for {
 		_, msg, err := conn.ReadMessage()
 
@@ -445,23 +1137,52 @@ func handleSocket(sm *ServerManager, conn *websocket.Conn) {
 			}
 			break
 		}
-		println("message received", string(msg))
-		var parsedMsg types.SocketClientMessageType
-		if err := json.Unmarshal(msg, &parsedMsg); err != nil {
-			log.Println("JSON Unmarshal Error:", err)
-			continue
-		}
+		queue <- msg
+	}
+}
 
-		switch parsedMsg.Type {
-		case "new_game":
-			go NewGameHandler(sm, conn, parsedMsg.Username)
-		case "game_update":
-			go GameUpdateHandler(sm, conn, parsedMsg.Username, parsedMsg.Data)
-		case "reconnect":
-			go ReconnectHandler(sm, conn, parsedMsg.Username, parsedMsg.Data)
-		default:
-			log.Println("Unknown message type:", parsedMsg.Type)
-		}
+////////////////////////////////////////////////
+// HANDLES ONE CLIENT MESSAGE
+// Every message is answered by exactly one ack or error, sent after
+// anything else the message caused.
+////////////////////////////////////////////////
+
+func handleMessage(sm *ServerManager, conn *client.Client, version int, msg []byte) {
+	var parsedMsg types.SocketClientMessageType
+	if err := json.Unmarshal(msg, &parsedMsg); err != nil {
+		log.Println("JSON Unmarshal Error:", err)
+		conn.Send(types.NewServerMessage(types.MsgError, types.ErrorData{
+			Code:  types.ErrCodeInvalidMessage,
+			Error: "Invalid message",
+		}))
+		return
+	}
+	// The connection's username comes from its access token. A message can
+	// repeat it but never act for someone else.
+	req := &Request{ID: parsedMsg.RequestID, Type: parsedMsg.Type, Username: conn.Username, Client: conn}
+	defer req.Ack()
+	// Only the type and ID are logged; the data can hold session tokens and chat.
+	println("message received", parsedMsg.Type, parsedMsg.RequestID, "from", conn.Username)
+
+	if parsedMsg.Username != "" && parsedMsg.Username != conn.Username {
+		req.Error(types.ErrCodeUsernameMismatch, "Username does not match connection")
+		return
+	}
+
+	if parsedMsg.Version != 0 && parsedMsg.Version != version {
+		req.Error(types.ErrCodeUnsupportedVersion, fmt.Sprintf("Unsupported protocol version %d, this connection uses version %d", parsedMsg.Version, version))
+		return
+	}
+
+	handle, known := messageHandlers[parsedMsg.Type]
+	if !known {
+		log.Println("Unknown message type:", parsedMsg.Type)
+		req.Error(types.ErrCodeUnknownMessageType, "Unknown message type: "+parsedMsg.Type)
+		return
+	}
+	if err := handle(sm, req, parsedMsg.Data); err != nil {
+		log.Println("Invalid", parsedMsg.Type, "data:", err)
+		req.Error(types.ErrCodeInvalidMessage, "Invalid "+parsedMsg.Type+" message")
 	}
 }
 
@@ -470,63 +1191,53 @@ func handleSocket(sm *ServerManager, conn *websocket.Conn) {
 // Handles player reconnection to a game
 ////////////////////////////////////////////////
 
-func ReconnectHandler(sm *ServerManager, conn *websocket.Conn, username string, data map[string]any) {
-	roomId, ok := data["room_id"].(string)
-	if !ok {
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "error",
-			Data: map[string]any{
-				"error": "Invalid room ID",
-			},
-		})
+func ReconnectHandler(sm *ServerManager, req *Request, msg types.ReconnectRequest) {
+	roomId := msg.RoomID
+	if roomId == "" {
+		req.Error(types.ErrCodeInvalidRoomID, "Invalid room ID")
 		return
 	}
 
 	r := room.GetRoomById(roomId)
-	if r == nil {
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "error",
-			Data: map[string]any{
-				"error": "Room not found",
-			},
-		})
+	joined := false
+	if r == nil || !r.Do(func() { joined = rejoin(req, r, msg.SessionToken) }) {
+		req.Error(types.ErrCodeRoomNotFound, "Room not found")
 		return
 	}
 
+	if joined {
+		sm.clientManager.AddPlayingClient(req.Username, roomId)
+	}
+}
+
+////////////////////////////////////////////////
+// REJOINS THE ROOM ON ITS GOROUTINE
+// Reports whether the player got their seat back
+////////////////////////////////////////////////
+
+func rejoin(req *Request, r *room.Room, token string) bool {
 	if r.Status == "finished" {
 		winnerMsg := "The game has ended."
 		if r.Winner != "" {
-			if r.Winner == username {
+			if r.Winner == req.Username {
 				winnerMsg = "You won the game!"
 			} else {
 				winnerMsg = "You lost the game."
 			}
 		}
 
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "game_update",
-			Data: map[string]any{
-				"room_id": r.ID,
-				"status":  "finished",
-				"winner":  r.Winner,
-				"message": winnerMsg,
-			},
-		})
-		return
+		req.Client.Send(types.NewServerMessage(types.MsgGameUpdate, types.GameUpdateData{
+			RoomID:  r.ID,
+			Status:  "finished",
+			Winner:  r.Winner,
+			Message: winnerMsg,
+		}))
+		return false
 	}
 
-	_, wasDisconnected := r.DisconnectedPlayers[username]
-	if !wasDisconnected {
-		conn.WriteJSON(types.SocketServerMessageType{
-			Type: "error",
-			Data: map[string]any{
-				"error": "You were not disconnected from this room",
-			},
-		})
-		return
+	if err := r.JoinPlayer(req.Username, req.Client, token); err != nil {
+		req.Fail(err)
+		return false
 	}
-
-	r.JoinPlayer(username, conn)
-
-	sm.clientManager.AddPlayingClient(username, roomId)
+	return true
 }
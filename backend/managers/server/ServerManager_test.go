@@ -0,0 +1,424 @@
+package server
+
+import (
+	"backend/auth"
+	"backend/bot"
+	"backend/clock"
+	"backend/db"
+	"backend/game"
+	"backend/managers/matchmaking"
+	"backend/managers/room"
+	"backend/managers/types"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+///////////////////////////////////////
+// These tests run whole games through the websocket handler, many at once,
+// so `go test -race` sees the rooms, the matchmaker and the client writers
+// working concurrently. No database is used.
+///////////////////////////////////////
+
+var testServer *httptest.Server
+
+func TestMain(m *testing.M) {
+	room.BotMoveDelay = 0
+	room.ReconnectWindow = 300 * time.Millisecond
+
+	sm := GetServerManager()
+	sm.matchmaker.Start()
+	testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebSocketHandler(sm, w, r)
+	}))
+
+	code := m.Run()
+	testServer.Close()
+	sm.matchmaker.Stop()
+	os.Exit(code)
+}
+
+// fatalf fails the test from any goroutine and stops the calling one.
+func fatalf(t *testing.T, format string, args ...any) {
+	t.Helper()
+	t.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// messageTimeout is how long a test player waits for an expected message.
+const messageTimeout = 10 * time.Second
+
+type envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type testPlayer struct {
+	t        *testing.T
+	username string
+	ws       *websocket.Conn
+	messages chan envelope
+}
+
+// connect opens a websocket for username with a guest access token.
+func connect(t *testing.T, username string) *testPlayer {
+	t.Helper()
+	token, _ := auth.Tokens.AccessToken(0, username, true, time.Now())
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/ws?access_token=" + url.QueryEscape(token)
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		fatalf(t, "%s cannot connect: %v", username, err)
+	}
+
+	p := &testPlayer{t: t, username: username, ws: ws, messages: make(chan envelope, 256)}
+	go func() {
+		defer close(p.messages)
+		for {
+			var msg envelope
+			if err := ws.ReadJSON(&msg); err != nil {
+				return
+			}
+			p.messages <- msg
+		}
+	}()
+	p.waitFor(types.MsgConnectionAck, nil)
+	return p
+}
+
+func (p *testPlayer) send(msgType string, data any) {
+	p.t.Helper()
+	payload, _ := json.Marshal(data)
+	if err := p.ws.WriteJSON(types.SocketClientMessageType{Type: msgType, Data: payload}); err != nil {
+		fatalf(p.t, "%s cannot send %s: %v", p.username, msgType, err)
+	}
+}
+
+// waitFor skips messages until one of msgType arrives and decodes its data
+// into v. Any error message fails the test.
+func (p *testPlayer) waitFor(msgType string, v any) {
+	p.t.Helper()
+	deadline := time.After(messageTimeout)
+	for {
+		select {
+		case msg, ok := <-p.messages:
+			if !ok {
+				fatalf(p.t, "%s was disconnected waiting for %s", p.username, msgType)
+			}
+			if msg.Type == types.MsgError {
+				fatalf(p.t, "%s got an error waiting for %s: %s", p.username, msgType, msg.Data)
+			}
+			if msg.Type != msgType {
+				continue
+			}
+			if v != nil {
+				if err := json.Unmarshal(msg.Data, v); err != nil {
+					fatalf(p.t, "%s cannot decode %s: %v", p.username, msgType, err)
+				}
+			}
+			return
+		case <-deadline:
+			fatalf(p.t, "%s timed out waiting for %s", p.username, msgType)
+		}
+	}
+}
+
+// drop closes the connection without a goodbye, as a lost network would.
+func (p *testPlayer) drop() {
+	p.ws.Close()
+}
+
+func (p *testPlayer) playMove(roomID string, column int) {
+	p.t.Helper()
+	c := float64(column)
+	p.send(types.MsgGameUpdate, types.GameUpdateRequest{RoomID: roomID, Action: types.ActionPlaceDisc, Column: &c})
+}
+
+// randomColumn picks a legal column in grid.
+func randomColumn(t *testing.T, rng *rand.Rand, grid [][]string) int {
+	t.Helper()
+	pos, err := game.PositionFromGrid(grid)
+	if err != nil {
+		fatalf(t, "server sent an impossible grid: %v", err)
+	}
+	moves := pos.LegalMoves()
+	return moves[rng.Intn(len(moves))]
+}
+
+///////////////////////////////////////
+// HUMAN GAMES
+///////////////////////////////////////
+
+type scenario int
+
+const (
+	playOut        scenario = iota // play to the end, then rematch and resign
+	reconnect                      // one player drops mid-game and comes back
+	forfeit                        // one player drops mid-game and never comes back
+	correspondence                 // one player drops for longer than ReconnectWindow and comes back
+	flagFall                       // the first player never moves
+)
+
+func (s scenario) String() string {
+	return [...]string{"play_out", "reconnect", "forfeit", "correspondence", "flag_fall"}[s]
+}
+
+// humanGame is one game between two connected players.
+type humanGame struct {
+	t       *testing.T
+	players map[string]*testPlayer
+	roomID  string
+	turn    string
+	grid    [][]string
+	tokens  map[string]string
+}
+
+// startHumanGame queues two players on a time control nobody else uses, so
+// the matchmaker pairs them with each other.
+func startHumanGame(t *testing.T, name string, timeControl json.RawMessage) *humanGame {
+	a := connect(t, name+"_a")
+	b := connect(t, name+"_b")
+	g := &humanGame{t: t, players: map[string]*testPlayer{a.username: a, b.username: b}, tokens: map[string]string{}}
+
+	request := map[string]any{"opponent": "human", "time_control": timeControl}
+	a.send(types.MsgNewGame, request)
+	b.send(types.MsgNewGame, request)
+
+	for _, p := range []*testPlayer{a, b} {
+		var started types.GameStateData
+		p.waitFor(types.MsgGameStarted, &started)
+		if g.roomID != "" && started.RoomID != g.roomID {
+			fatalf(t, "%s and %s were put in different rooms", a.username, b.username)
+		}
+		g.roomID, g.turn, g.grid = started.RoomID, started.CurrentTurn, started.GridData
+		g.tokens[p.username] = started.SessionToken
+		if started.SessionToken == "" {
+			fatalf(t, "%s got no session token", p.username)
+		}
+	}
+	return g
+}
+
+func (g *humanGame) opponent(username string) *testPlayer {
+	for name, p := range g.players {
+		if name != username {
+			return p
+		}
+	}
+	return nil
+}
+
+// move plays a random column for the player to move and waits until both
+// players saw it. It reports whether the game goes on.
+func (g *humanGame) move(rng *rand.Rand) bool {
+	mover := g.players[g.turn]
+	mover.playMove(g.roomID, randomColumn(g.t, rng, g.grid))
+
+	var update types.GameUpdateData
+	for _, p := range g.players {
+		p.waitFor(types.MsgGameUpdate, &update)
+	}
+	if update.CurrentTurn == mover.username && update.Status == "playing" {
+		fatalf(g.t, "turn did not pass after %s moved", mover.username)
+	}
+	g.turn, g.grid = update.CurrentTurn, update.GridData
+	return update.Status == "playing"
+}
+
+// rejoin reconnects username on a new connection with their session token.
+func (g *humanGame) rejoin(username string) {
+	p := connect(g.t, username)
+	g.players[username] = p
+	p.send(types.MsgReconnect, types.ReconnectRequest{RoomID: g.roomID, SessionToken: g.tokens[username]})
+
+	var rejoined types.GameStateData
+	p.waitFor(types.MsgGameRejoined, &rejoined)
+	if rejoined.SessionToken == "" || rejoined.SessionToken == g.tokens[username] {
+		fatalf(g.t, "%s did not get a fresh session token", username)
+	}
+	g.tokens[username] = rejoined.SessionToken
+	g.opponent(username).waitFor(types.MsgPlayerRejoined, nil)
+}
+
+// waitForEnd waits until p sees the game end and checks how.
+func (g *humanGame) waitForEnd(p *testPlayer, winner, termination string) {
+	var update types.GameUpdateData
+	for update.Status != "finished" {
+		p.waitFor(types.MsgGameUpdate, &update)
+	}
+	if update.Winner != winner || update.Termination != termination {
+		fatalf(g.t, "game ended with winner %q by %q, want %q by %q", update.Winner, update.Termination, winner, termination)
+	}
+}
+
+func runHumanGame(t *testing.T, name string, s scenario, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+
+	// Every game gets its own time control so only its two players match.
+	timeControl := json.RawMessage(fmt.Sprintf(`{"mode":"per_move","seconds":%d}`, 60+seed))
+	switch s {
+	case correspondence:
+		timeControl = json.RawMessage(fmt.Sprintf(`{"mode":"correspondence","days":%g}`, 1+float64(seed)/100))
+	case flagFall:
+		timeControl = json.RawMessage(fmt.Sprintf(`{"mode":"per_move","seconds":%g}`, 5+float64(seed)/100))
+	}
+	g := startHumanGame(t, name, timeControl)
+
+	if s == flagFall {
+		idle := g.players[g.turn]
+		winner := g.opponent(idle.username)
+		g.waitForEnd(winner, winner.username, db.TerminationTimeout)
+		return
+	}
+
+	for moves := 0; ; moves++ {
+		if moves == 4 && s != playOut {
+			dropped := g.players[g.turn]
+			other := g.opponent(dropped.username)
+			dropped.drop()
+			other.waitFor(types.MsgPlayerDisconnected, nil)
+
+			switch s {
+			case reconnect:
+				g.rejoin(dropped.username)
+			case forfeit:
+				g.waitForEnd(other, other.username, db.TerminationDisconnectTimeout)
+				return
+			case correspondence:
+				time.Sleep(2 * room.ReconnectWindow)
+				g.rejoin(dropped.username)
+			}
+		}
+		if !g.move(rng) {
+			break
+		}
+	}
+
+	if s != playOut {
+		return
+	}
+
+	// A rematch between the same two players, resigned straight away.
+	var offerer, accepter *testPlayer
+	for _, p := range g.players {
+		if offerer == nil {
+			offerer = p
+		} else {
+			accepter = p
+		}
+	}
+	finished := g.roomID
+	offerer.send(types.MsgRematchOffer, types.RoomRequest{RoomID: finished})
+	accepter.waitFor(types.MsgRematchOffered, nil)
+	accepter.send(types.MsgRematchAccept, types.RoomRequest{RoomID: finished})
+
+	var started types.GameStateData
+	offerer.waitFor(types.MsgGameStarted, &started)
+	accepter.waitFor(types.MsgGameStarted, nil)
+	if started.RoomID == finished {
+		fatalf(t, "the rematch reused room %s", finished)
+	}
+	g.roomID = started.RoomID
+
+	offerer.send(types.MsgGameUpdate, types.GameUpdateRequest{RoomID: g.roomID, Action: types.ActionResign})
+	g.waitForEnd(accepter, accepter.username, db.TerminationResignation)
+}
+
+///////////////////////////////////////
+// BOT GAMES
+///////////////////////////////////////
+
+func runBotGame(t *testing.T, name string, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	p := connect(t, name)
+	p.send(types.MsgNewGame, map[string]any{
+		"opponent":     "any",
+		"bot_after":    0,
+		"difficulty":   "easy",
+		"time_control": json.RawMessage(fmt.Sprintf(`{"mode":"per_move","seconds":%d}`, 300+seed)),
+	})
+
+	var started types.GameStateData
+	p.waitFor(types.MsgGameStarted, &started)
+	if started.OpponentType != "bot" {
+		fatalf(t, "%s was matched with %s, want the bot", name, started.OpponentUsername)
+	}
+	playBot(t, p, rng, started)
+}
+
+// playBot plays random moves against the bot until the game ends and
+// returns the final update.
+func playBot(t *testing.T, p *testPlayer, rng *rand.Rand, started types.GameStateData) types.GameUpdateData {
+	update := types.GameUpdateData{Status: started.Status, CurrentTurn: started.CurrentTurn, GridData: started.GridData}
+	for update.Status == "playing" {
+		if update.CurrentTurn == p.username {
+			p.playMove(started.RoomID, randomColumn(t, rng, update.GridData))
+		}
+		p.waitFor(types.MsgGameUpdate, &update)
+	}
+	return update
+}
+
+// TestBotKeepsToItsClock gives the slowest bot less time for the whole game
+// than its level allows for one move. It must still not lose on time.
+func TestBotKeepsToItsClock(t *testing.T) {
+	p := connect(t, "short_clock")
+	GetServerManager().StartMatch(matchmaking.Match{First: matchmaking.Ticket{
+		Username:      p.username,
+		BotDifficulty: bot.Perfect,
+		TimeControl:   clock.TimeControl{Mode: clock.Fischer, Initial: 2 * time.Second},
+	}})
+
+	var started types.GameStateData
+	p.waitFor(types.MsgGameStarted, &started)
+	final := playBot(t, p, rand.New(rand.NewSource(1)), started)
+	if final.Termination == db.TerminationTimeout && final.Winner == p.username {
+		t.Fatalf("the bot lost on time")
+	}
+}
+
+///////////////////////////////////////
+// TestConcurrentGames runs games of every kind side by side, each on its
+// own goroutine.
+///////////////////////////////////////
+
+func TestConcurrentGames(t *testing.T) {
+	const gamesPerScenario = 8
+
+	var wg sync.WaitGroup
+	seed := int64(0)
+	run := func(play func(seed int64)) {
+		seed++
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			play(seed)
+		}(seed)
+	}
+
+	for _, s := range []scenario{playOut, reconnect, forfeit, correspondence, flagFall} {
+		for i := 0; i < gamesPerScenario; i++ {
+			name := fmt.Sprintf("%s_%d", s, i)
+			run(func(seed int64) { runHumanGame(t, name, s, seed) })
+		}
+	}
+	for i := 0; i < gamesPerScenario; i++ {
+		name := fmt.Sprintf("bot_%d", i)
+		run(func(seed int64) { runBotGame(t, name, seed) })
+	}
+	wg.Wait()
+
+	for _, r := range room.GetLiveRooms() {
+		t.Errorf("room %s is still playing after every game ended", r.RoomID)
+	}
+}
@@ -0,0 +1,98 @@
+package types
+
+import "backend/clock"
+
+///////////////////////////////////////////////
+// CLIENT MESSAGE TYPES
+///////////////////////////////////////////////
+
+const (
+	MsgNewGame           = "new_game"
+	MsgGameUpdate        = "game_update"
+	MsgReconnect         = "reconnect"
+	MsgCancelSearch      = "cancel_search"
+	MsgCreatePrivateGame = "create_private_game"
+	MsgJoinPrivateGame   = "join_private_game"
+	MsgCancelPrivateGame = "cancel_private_game"
+	MsgSpectate          = "spectate"
+	MsgStopSpectating    = "stop_spectating"
+	MsgRematchOffer      = "rematch_offer"
+	MsgRematchAccept     = "rematch_accept"
+	MsgChatMessage       = "chat_message"
+	MsgEmote             = "emote"
+	MsgPing              = "ping"
+)
+
+// Actions carried by a game_update message.
+const (
+	ActionPlaceDisc       = "place_disc"
+	ActionResign          = "resign"
+	ActionOfferDraw       = "offer_draw"
+	ActionAcceptDraw      = "accept_draw"
+	ActionDeclineDraw     = "decline_draw"
+	ActionAbort           = "abort"
+	ActionTakebackRequest = "takeback_request"
+	ActionTakebackAccept  = "takeback_accept"
+	ActionTakebackDecline = "takeback_decline"
+)
+
+var Actions = []string{
+	ActionPlaceDisc, ActionResign, ActionOfferDraw, ActionAcceptDraw, ActionDeclineDraw,
+	ActionAbort, ActionTakebackRequest, ActionTakebackAccept, ActionTakebackDecline,
+}
+
+///////////////////////////////////////////////
+// CLIENT MESSAGE DATA
+///////////////////////////////////////////////
+
+type EmptyRequest struct{}
+
+type NewGameRequest struct {
+	Difficulty  string      `json:"difficulty,omitempty"` // bot level if the bot joins
+	Opponent    string      `json:"opponent,omitempty"`   // "any" or "human"
+	BotAfter    *float64    `json:"bot_after,omitempty"`  // seconds before the bot joins
+	TimeControl *clock.Spec `json:"time_control,omitempty"`
+}
+
+type GameUpdateRequest struct {
+	RoomID      string   `json:"room_id"`
+	Action      string   `json:"action"`
+	Column      *float64 `json:"column,omitempty"`       // place_disc only
+	PlayerColor string   `json:"player_color,omitempty"` // place_disc only, checked against the seat
+}
+
+type RoomRequest struct {
+	RoomID string `json:"room_id"`
+}
+
+// ReconnectRequest asks for a seat back after a disconnect. SessionToken is
+// the one the player last got in game_started or game_rejoined.
+type ReconnectRequest struct {
+	RoomID       string `json:"room_id"`
+	SessionToken string `json:"session_token"`
+}
+
+type CreatePrivateGameRequest struct {
+	TimeControl *clock.Spec `json:"time_control,omitempty"`
+	Rated       bool        `json:"rated,omitempty"`
+}
+
+type JoinPrivateGameRequest struct {
+	InviteCode string `json:"invite_code"`
+}
+
+type ChatRequest struct {
+	RoomID string `json:"room_id"`
+	Text   string `json:"text"`
+}
+
+type EmoteRequest struct {
+	RoomID string `json:"room_id"`
+	Emote  string `json:"emote"`
+}
+
+// PingRequest asks for a pong. SentAt is any timestamp the client wants
+// echoed back, typically its own clock in milliseconds.
+type PingRequest struct {
+	SentAt *float64 `json:"sent_at,omitempty"`
+}
@@ -0,0 +1,100 @@
+package types
+
+///////////////////////////////////////////////
+// ERROR CODES
+// Every error message carries one of these codes so clients can react to
+// it without matching the human-readable text, which may change.
+///////////////////////////////////////////////
+
+type ErrorCode string
+
+const (
+	// Messages
+	ErrCodeInvalidMessage     ErrorCode = "INVALID_MESSAGE"
+	ErrCodeUnknownMessageType ErrorCode = "UNKNOWN_MESSAGE_TYPE"
+	ErrCodeUnsupportedVersion ErrorCode = "UNSUPPORTED_VERSION"
+	ErrCodeUsernameMismatch   ErrorCode = "USERNAME_MISMATCH"
+	ErrCodeInvalidAction      ErrorCode = "INVALID_ACTION"
+	ErrCodeInvalidRoomID      ErrorCode = "INVALID_ROOM_ID"
+	ErrCodeRoomNotFound       ErrorCode = "ROOM_NOT_FOUND"
+	ErrCodeInternal           ErrorCode = "INTERNAL_ERROR"
+
+	// Matchmaking
+	ErrCodeInvalidDifficulty  ErrorCode = "INVALID_DIFFICULTY"
+	ErrCodeInvalidOpponent    ErrorCode = "INVALID_OPPONENT"
+	ErrCodeInvalidBotAfter    ErrorCode = "INVALID_BOT_AFTER"
+	ErrCodeInvalidTimeControl ErrorCode = "INVALID_TIME_CONTROL"
+	ErrCodeAlreadySearching   ErrorCode = "ALREADY_SEARCHING"
+	ErrCodeNotSearching       ErrorCode = "NOT_SEARCHING"
+
+	// Private games
+	ErrCodeInviteNotFound ErrorCode = "INVITE_NOT_FOUND"
+	ErrCodeOwnInvite      ErrorCode = "OWN_INVITE"
+	ErrCodeNoInvite       ErrorCode = "NO_INVITE"
+
+	// Spectating
+	ErrCodeNoGameInProgress ErrorCode = "NO_GAME_IN_PROGRESS"
+	ErrCodePlayingInRoom    ErrorCode = "PLAYING_IN_ROOM"
+	ErrCodeNotSpectating    ErrorCode = "NOT_SPECTATING"
+
+	// Moves
+	ErrCodeGameNotInProgress ErrorCode = "GAME_NOT_IN_PROGRESS"
+	ErrCodeNotSeated         ErrorCode = "NOT_SEATED"
+	ErrCodeNotYourTurn       ErrorCode = "NOT_YOUR_TURN"
+	ErrCodeInvalidColumn     ErrorCode = "INVALID_COLUMN"
+	ErrCodeColumnFull        ErrorCode = "COLUMN_FULL"
+	ErrCodeColumnOutOfRange  ErrorCode = "COLUMN_OUT_OF_RANGE"
+	ErrCodeColorMismatch     ErrorCode = "COLOR_MISMATCH"
+	ErrCodeTimeExpired       ErrorCode = "TIME_EXPIRED"
+	ErrCodeInvalidMove       ErrorCode = "INVALID_MOVE"
+
+	// Draws, aborts and takebacks
+	ErrCodeNoDrawOffer       ErrorCode = "NO_DRAW_OFFER"
+	ErrCodeDrawOfferOpen     ErrorCode = "DRAW_OFFER_OPEN"
+	ErrCodeBotDeclinesDraw   ErrorCode = "BOT_DECLINES_DRAW"
+	ErrCodeAbortTooLate      ErrorCode = "ABORT_TOO_LATE"
+	ErrCodeTakebacksDisabled ErrorCode = "TAKEBACKS_DISABLED"
+	ErrCodeNothingToTakeBack ErrorCode = "NOTHING_TO_TAKE_BACK"
+	ErrCodeTakebackLimit     ErrorCode = "TAKEBACK_LIMIT"
+	ErrCodeTakebackOfferOpen ErrorCode = "TAKEBACK_OFFER_OPEN"
+	ErrCodeNoTakebackOffer   ErrorCode = "NO_TAKEBACK_OFFER"
+
+	// Rematches
+	ErrCodeNoFinishedGame   ErrorCode = "NO_FINISHED_GAME"
+	ErrCodeInAnotherGame    ErrorCode = "IN_ANOTHER_GAME"
+	ErrCodeRematchExpired   ErrorCode = "REMATCH_EXPIRED"
+	ErrCodeRematchOfferOpen ErrorCode = "REMATCH_OFFER_OPEN"
+	ErrCodeNoRematchOffer   ErrorCode = "NO_REMATCH_OFFER"
+	ErrCodeOpponentLeft     ErrorCode = "OPPONENT_LEFT"
+	ErrCodeOpponentBusy     ErrorCode = "OPPONENT_BUSY"
+
+	// Chat
+	ErrCodeChatEmpty    ErrorCode = "CHAT_EMPTY"
+	ErrCodeChatTooLong  ErrorCode = "CHAT_TOO_LONG"
+	ErrCodeUnknownEmote ErrorCode = "UNKNOWN_EMOTE"
+	ErrCodeRateLimited  ErrorCode = "RATE_LIMITED"
+	ErrCodeChatClosed   ErrorCode = "CHAT_CLOSED"
+
+	// Reconnecting
+	ErrCodeNotDisconnected ErrorCode = "NOT_DISCONNECTED"
+	ErrCodeRejoinExpired   ErrorCode = "REJOIN_EXPIRED"
+	ErrCodeInvalidSession  ErrorCode = "INVALID_SESSION"
+	ErrCodeSessionExpired  ErrorCode = "SESSION_EXPIRED"
+	ErrCodeSessionRevoked  ErrorCode = "SESSION_REVOKED"
+)
+
+var ErrorCodes = []ErrorCode{
+	ErrCodeInvalidMessage, ErrCodeUnknownMessageType, ErrCodeUnsupportedVersion, ErrCodeUsernameMismatch,
+	ErrCodeInvalidAction, ErrCodeInvalidRoomID, ErrCodeRoomNotFound, ErrCodeInternal,
+	ErrCodeInvalidDifficulty, ErrCodeInvalidOpponent, ErrCodeInvalidBotAfter, ErrCodeInvalidTimeControl,
+	ErrCodeAlreadySearching, ErrCodeNotSearching,
+	ErrCodeInviteNotFound, ErrCodeOwnInvite, ErrCodeNoInvite,
+	ErrCodeNoGameInProgress, ErrCodePlayingInRoom, ErrCodeNotSpectating,
+	ErrCodeGameNotInProgress, ErrCodeNotSeated, ErrCodeNotYourTurn, ErrCodeInvalidColumn, ErrCodeColumnFull,
+	ErrCodeColumnOutOfRange, ErrCodeColorMismatch, ErrCodeTimeExpired, ErrCodeInvalidMove,
+	ErrCodeNoDrawOffer, ErrCodeDrawOfferOpen, ErrCodeBotDeclinesDraw, ErrCodeAbortTooLate,
+	ErrCodeTakebacksDisabled, ErrCodeNothingToTakeBack, ErrCodeTakebackLimit, ErrCodeTakebackOfferOpen, ErrCodeNoTakebackOffer,
+	ErrCodeNoFinishedGame, ErrCodeInAnotherGame, ErrCodeRematchExpired, ErrCodeRematchOfferOpen, ErrCodeNoRematchOffer, ErrCodeOpponentLeft, ErrCodeOpponentBusy,
+	ErrCodeChatEmpty, ErrCodeChatTooLong, ErrCodeUnknownEmote, ErrCodeRateLimited, ErrCodeChatClosed,
+	ErrCodeNotDisconnected, ErrCodeRejoinExpired, ErrCodeInvalidSession, ErrCodeSessionExpired, ErrCodeSessionRevoked,
+}
@@ -0,0 +1,197 @@
+package types
+
+import (
+	"backend/bot"
+	"backend/clock"
+	"backend/db"
+	"backend/game"
+	"time"
+)
+
+///////////////////////////////////////////////
+// SERVER MESSAGE TYPES
+///////////////////////////////////////////////
+
+const (
+	MsgConnectionAck        = "connection_ack"
+	MsgAck                  = "ack"
+	MsgError                = "error"
+	MsgInfo                 = "info"
+	MsgNewGameResponse      = "new_game_response"
+	MsgGameStarted          = "game_started"
+	MsgGameRejoined         = "game_rejoined"
+	MsgGameJoined           = "game_joined"
+	MsgPlayerDisconnected   = "player_disconnected"
+	MsgPlayerRejoined       = "player_rejoined"
+	MsgPrivateGameCreated   = "private_game_created"
+	MsgPrivateGameExpired   = "private_game_expired"
+	MsgPrivateGameCancelled = "private_game_cancelled"
+	MsgSpectateStarted      = "spectate_started"
+	MsgSpectateStopped      = "spectate_stopped"
+	MsgDrawOffered          = "draw_offered"
+	MsgDrawDeclined         = "draw_declined"
+	MsgTakebackRequested    = "takeback_requested"
+	MsgTakebackDeclined     = "takeback_declined"
+	MsgRematchOffered       = "rematch_offered"
+	MsgRematchWithdrawn     = "rematch_withdrawn"
+	MsgPong                 = "pong"
+)
+
+///////////////////////////////////////////////
+// SERVER MESSAGE DATA
+///////////////////////////////////////////////
+
+type EmptyData struct{}
+
+type ConnectionAckData struct {
+	Username   string `json:"username"`
+	Guest      bool   `json:"guest,omitempty"`
+	Version    int    `json:"version"`
+	MinVersion int    `json:"min_version"`
+	MaxVersion int    `json:"max_version"`
+}
+
+// ErrorData rejects a client request. Code is stable, Error is for people
+// and RequestID echoes the request_id of the message being answered.
+type ErrorData struct {
+	Code        ErrorCode  `json:"code"`
+	Error       string     `json:"error"`
+	RequestID   string     `json:"request_id,omitempty"`
+	Action      string     `json:"action,omitempty"`
+	RoomID      string     `json:"room_id,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	CurrentTurn string     `json:"current_turn,omitempty"`
+	GridData    [][]string `json:"grid_data,omitempty"`
+	Column      *float64   `json:"column,omitempty"` // the rejected column as the client sent it
+}
+
+// AckData confirms that the client message with RequestID was carried out.
+type AckData struct {
+	RequestID string `json:"request_id"`
+	Type      string `json:"type"` // type of the message being acknowledged
+}
+
+type InfoData struct {
+	Info string `json:"info"`
+}
+
+type NewGameResponseData struct {
+	Status        string            `json:"status"`
+	Rating        int               `json:"rating"`
+	HumanOnly     bool              `json:"human_only"`
+	BotAfter      float64           `json:"bot_after"` // seconds
+	BotDifficulty bot.Difficulty    `json:"bot_difficulty"`
+	TimeControl   clock.TimeControl `json:"time_control"`
+}
+
+///////////////////////////////////////////////
+// GameStateData is the full view of a game for one player, sent when the
+// game starts and when they rejoin it. SessionToken is what the player
+// must send to reconnect, and each one replaces the last.
+///////////////////////////////////////////////
+
+type GameStateData struct {
+	RoomID           string           `json:"room_id"`
+	Status           string           `json:"status"`
+	OpponentType     string           `json:"opponent_type,omitempty"`
+	CurrentTurn      string           `json:"current_turn"`
+	TotalPlayers     int              `json:"total_players"`
+	Players          []string         `json:"players"`
+	GridData         [][]string       `json:"grid_data"`
+	PlayerUsername   string           `json:"player_username,omitempty"`
+	PlayerColor      game.Player      `json:"player_color,omitempty"`
+	OpponentUsername string           `json:"opponent_username,omitempty"`
+	OpponentColor    game.Player      `json:"opponent_color,omitempty"`
+	BotDifficulty    bot.Difficulty   `json:"bot_difficulty,omitempty"`
+	Rated            bool             `json:"rated"`
+	Clock            *ClockState      `json:"clock,omitempty"`
+	Chat             []ChatLine       `json:"chat,omitempty"`
+	LatencyMs        map[string]int64 `json:"latency_ms,omitempty"` // round trip to each connected player
+	SessionToken     string           `json:"session_token,omitempty"`
+}
+
+// GameUpdateData reports a change to a game in progress, or its end.
+type GameUpdateData struct {
+	RoomID        string                     `json:"room_id"`
+	Status        string                     `json:"status"`
+	CurrentTurn   string                     `json:"current_turn,omitempty"`
+	GridData      [][]string                 `json:"grid_data,omitempty"`
+	Clock         *ClockState                `json:"clock,omitempty"`
+	Winner        string                     `json:"winner,omitempty"`
+	Draw          bool                       `json:"draw,omitempty"`
+	Termination   string                     `json:"termination,omitempty"`
+	RatingChanges map[string]db.RatingChange `json:"rating_changes,omitempty"`
+	Message       string                     `json:"message,omitempty"`
+	Takeback      bool                       `json:"takeback,omitempty"`
+	LatencyMs     map[string]int64           `json:"latency_ms,omitempty"` // sent with moves
+}
+
+type ClockState struct {
+	TimeControl clock.TimeControl `json:"time_control"`
+	RemainingMs map[string]int64  `json:"remaining_ms"` // by username
+	Running     string            `json:"running"`      // username whose clock runs, empty when stopped
+}
+
+// PlayerEventData names the player something happened to or came from:
+// a disconnect, a rejoin, a draw offer, a takeback request or a withdrawn
+// rematch offer.
+type PlayerEventData struct {
+	RoomID   string `json:"room_id,omitempty"`
+	Username string `json:"username"`
+	Message  string `json:"message,omitempty"`
+}
+
+type PrivateGameData struct {
+	RoomID      string             `json:"room_id"`
+	InviteCode  string             `json:"invite_code"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty"`
+	Status      string             `json:"status,omitempty"`
+	TimeControl *clock.TimeControl `json:"time_control,omitempty"`
+	Rated       bool               `json:"rated,omitempty"`
+	Message     string             `json:"message,omitempty"`
+}
+
+// SpectateData is the full state of a room a spectator needs to start watching.
+type SpectateData struct {
+	RoomID        string                 `json:"room_id"`
+	Status        string                 `json:"status"`
+	OpponentType  string                 `json:"opponent_type"`
+	BotDifficulty bot.Difficulty         `json:"bot_difficulty,omitempty"`
+	CurrentTurn   string                 `json:"current_turn"`
+	Players       map[string]game.Player `json:"players"` // seat color by username
+	GridData      [][]string             `json:"grid_data"`
+	Moves         []db.GameMove          `json:"moves,omitempty"`
+	Spectators    int                    `json:"spectators"`
+	StartedAt     time.Time              `json:"started_at"`
+	Clock         *ClockState            `json:"clock,omitempty"`
+	Chat          []ChatLine             `json:"chat,omitempty"`
+	LatencyMs     map[string]int64       `json:"latency_ms,omitempty"`
+}
+
+type RematchOfferedData struct {
+	RoomID    string    `json:"room_id"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChatLine is one chat message or emote, stamped by the server.
+type ChatLine struct {
+	Kind     string    `json:"kind"` // "chat" or "emote"
+	Username string    `json:"username"`
+	Text     string    `json:"text,omitempty"`
+	Emote    string    `json:"emote,omitempty"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// PongData answers a ping. LatencyMs is the round trip the server last
+// measured with a websocket ping, 0 until it has one.
+type PongData struct {
+	SentAt     *float64  `json:"sent_at,omitempty"`
+	ServerTime time.Time `json:"server_time"`
+	LatencyMs  int64     `json:"latency_ms"`
+}
+
+type ChatData struct {
+	RoomID string   `json:"room_id"`
+	Line   ChatLine `json:"line"`
+}
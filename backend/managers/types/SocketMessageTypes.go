@@ -1,12 +1,125 @@
 package types
 
+import (
+	"encoding/json"
+)
+
+//go:generate go run ../../cmd/protogen -out ../../../frontend/src/types/ProtocolTypes.ts
+
+///////////////////////////////////////////////
+// PROTOCOL VERSION
+// Clients ask for a version with ?version= when connecting and the server
+// answers with connection_ack. Messages may repeat the version they were
+// written for; a message for another version is rejected.
+///////////////////////////////////////////////
+
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// NegotiateVersion picks the version to speak with a client that asked for
+// requested, where 0 means the client did not say. It reports false if the
+// server cannot speak any version the client understands.
+func NegotiateVersion(requested int) (int, bool) {
+	if requested == 0 {
+		return ProtocolVersion, true
+	}
+	if requested < MinProtocolVersion {
+		return 0, false
+	}
+	return min(requested, ProtocolVersion), true
+}
+
+///////////////////////////////////////////////
+// ENVELOPES
+///////////////////////////////////////////////
+
 type SocketClientMessageType struct {
-	Type     string         `json:"type"`
-	Username string         `json:"username"`
-	Data     map[string]any `json:"data"`
+	Type      string          `json:"type"`
+	Version   int             `json:"version,omitempty"`
+	RequestID string          `json:"request_id,omitempty"` // echoed in the ack or error answering this message
+	Username  string          `json:"username,omitempty"`   // optional, must be the connection's
+	Data      json.RawMessage `json:"data"`
 }
 
 type SocketServerMessageType struct {
-	Type string                 `json:"type"`
-	Data map[string]interface{} `json:"data"`
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	Data    any    `json:"data"`
+}
+
+// NewServerMessage wraps data, which should be one of the *Data structs of
+// this package, in an envelope for the current protocol version.
+func NewServerMessage(msgType string, data any) SocketServerMessageType {
+	return SocketServerMessageType{
+		Type:    msgType,
+		Version: ProtocolVersion,
+		Data:    data,
+	}
+}
+
+// DecodeData unmarshals the data of a client message into req. Missing data
+// leaves req at its zero value.
+func DecodeData(data json.RawMessage, req any) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, req)
+}
+
+///////////////////////////////////////////////
+// MESSAGE CATALOGUE
+// Every message type with the struct its data decodes to. cmd/protogen
+// generates the frontend's TypeScript definitions from these lists.
+///////////////////////////////////////////////
+
+type MessageSpec struct {
+	Type string
+	Data any
+}
+
+var ClientMessages = []MessageSpec{
+	{MsgNewGame, NewGameRequest{}},
+	{MsgGameUpdate, GameUpdateRequest{}},
+	{MsgReconnect, ReconnectRequest{}},
+	{MsgCancelSearch, EmptyRequest{}},
+	{MsgCreatePrivateGame, CreatePrivateGameRequest{}},
+	{MsgJoinPrivateGame, JoinPrivateGameRequest{}},
+	{MsgCancelPrivateGame, EmptyRequest{}},
+	{MsgSpectate, RoomRequest{}},
+	{MsgStopSpectating, EmptyRequest{}},
+	{MsgRematchOffer, RoomRequest{}},
+	{MsgRematchAccept, RoomRequest{}},
+	{MsgChatMessage, ChatRequest{}},
+	{MsgEmote, EmoteRequest{}},
+	{MsgPing, PingRequest{}},
+}
+
+var ServerMessages = []MessageSpec{
+	{MsgConnectionAck, ConnectionAckData{}},
+	{MsgAck, AckData{}},
+	{MsgError, ErrorData{}},
+	{MsgInfo, InfoData{}},
+	{MsgNewGameResponse, NewGameResponseData{}},
+	{MsgGameStarted, GameStateData{}},
+	{MsgGameRejoined, GameStateData{}},
+	{MsgGameJoined, GameStateData{}},
+	{MsgGameUpdate, GameUpdateData{}},
+	{MsgPlayerDisconnected, PlayerEventData{}},
+	{MsgPlayerRejoined, PlayerEventData{}},
+	{MsgPrivateGameCreated, PrivateGameData{}},
+	{MsgPrivateGameExpired, PrivateGameData{}},
+	{MsgPrivateGameCancelled, PrivateGameData{}},
+	{MsgSpectateStarted, SpectateData{}},
+	{MsgSpectateStopped, EmptyData{}},
+	{MsgDrawOffered, PlayerEventData{}},
+	{MsgDrawDeclined, PlayerEventData{}},
+	{MsgTakebackRequested, PlayerEventData{}},
+	{MsgTakebackDeclined, PlayerEventData{}},
+	{MsgRematchOffered, RematchOfferedData{}},
+	{MsgRematchWithdrawn, PlayerEventData{}},
+	{MsgChatMessage, ChatData{}},
+	{MsgEmote, ChatData{}},
+	{MsgPong, PongData{}},
 }
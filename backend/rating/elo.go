@@ -0,0 +1,61 @@
+package rating
+
+import "math"
+
+///////////////////////////////////////
+// SCORES
+///////////////////////////////////////
+
+const (
+	Win  = 1.0
+	Draw = 0.5
+	Loss = 0.0
+)
+
+// DefaultKFactor is used when no K-factor is configured.
+const DefaultKFactor = 32
+
+///////////////////////////////////////
+// ELO
+// Expected-score based Elo. KFactor is the largest change a single game
+// can cause.
+///////////////////////////////////////
+
+type Elo struct {
+	KFactor float64
+}
+
+func NewElo(kFactor float64) Elo {
+	if kFactor <= 0 {
+		kFactor = DefaultKFactor
+	}
+	return Elo{KFactor: kFactor}
+}
+
+///////////////////////////////////////
+// Expected returns the probability that a player rated rating scores
+// against a player rated opponent.
+///////////////////////////////////////
+
+func (e Elo) Expected(rating, opponent int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponent-rating)/400))
+}
+
+///////////////////////////////////////
+// Update returns a player's new rating after scoring score (Win, Draw or
+// Loss) against opponent. Ratings never drop below zero.
+///////////////////////////////////////
+
+func (e Elo) Update(rating, opponent int, score float64) int {
+	updated := float64(rating) + e.KFactor*(score-e.Expected(rating, opponent))
+	return max(0, int(math.Round(updated)))
+}
+
+///////////////////////////////////////
+// Game returns both new ratings after a game between a and b, where
+// scoreA is a's score.
+///////////////////////////////////////
+
+func (e Elo) Game(a, b int, scoreA float64) (int, int) {
+	return e.Update(a, b, scoreA), e.Update(b, a, 1-scoreA)
+}
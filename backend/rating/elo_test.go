@@ -0,0 +1,92 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+var testRatings = []int{0, 100, 800, 1200, 1199, 1500, 2000, 2800}
+
+func TestExpectedSumsToOne(t *testing.T) {
+	elo := NewElo(DefaultKFactor)
+	for _, a := range testRatings {
+		for _, b := range testRatings {
+			if sum := elo.Expected(a, b) + elo.Expected(b, a); math.Abs(sum-1) > 1e-9 {
+				t.Errorf("Expected(%d, %d) + Expected(%d, %d) = %v, want 1", a, b, b, a, sum)
+			}
+		}
+	}
+	if got := elo.Expected(1500, 1500); got != 0.5 {
+		t.Errorf("Expected between equal ratings = %v, want 0.5", got)
+	}
+	if elo.Expected(1600, 1500) <= 0.5 {
+		t.Error("the higher rated player is not expected to score more")
+	}
+}
+
+func TestGameIsZeroSum(t *testing.T) {
+	for _, k := range []float64{16, DefaultKFactor, 40} {
+		elo := NewElo(k)
+		for _, a := range testRatings {
+			for _, b := range testRatings {
+				for _, score := range []float64{Win, Draw, Loss} {
+					newA, newB := elo.Game(a, b, score)
+					// The floor is the one place points can appear.
+					if newA == 0 || newB == 0 {
+						continue
+					}
+					if newA+newB != a+b {
+						t.Errorf("K %v, %d vs %d scoring %v: %d + %d, want a total of %d", k, a, b, score, newA, newB, a+b)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	elo := NewElo(DefaultKFactor)
+	tests := []struct {
+		name             string
+		rating, opponent int
+		score            float64
+		want             int
+	}{
+		{"win between equals", 1500, 1500, Win, 1516},
+		{"loss between equals", 1500, 1500, Loss, 1484},
+		{"draw between equals", 1500, 1500, Draw, 1500},
+		{"draw against stronger", 1500, 1900, Draw, 1513},
+		{"upset win", 1100, 1900, Win, 1132},
+		{"expected win", 1900, 1100, Win, 1900},
+		{"floor", 10, 10, Loss, 0},
+		{"loss at zero", 0, 0, Loss, 0},
+	}
+	for _, tt := range tests {
+		if got := elo.Update(tt.rating, tt.opponent, tt.score); got != tt.want {
+			t.Errorf("%s: Update(%d, %d, %v) = %d, want %d", tt.name, tt.rating, tt.opponent, tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestDrawBetweenEqualsIsSymmetric(t *testing.T) {
+	elo := NewElo(DefaultKFactor)
+	for _, r := range testRatings {
+		if a, b := elo.Game(r, r, Draw); a != r || b != r {
+			t.Errorf("draw at %d: ratings became %d and %d", r, a, b)
+		}
+	}
+	// Swapping the players swaps the result.
+	for _, score := range []float64{Win, Draw, Loss} {
+		a, b := elo.Game(1400, 1650, score)
+		b2, a2 := elo.Game(1650, 1400, 1-score)
+		if a != a2 || b != b2 {
+			t.Errorf("scoring %v: Game gives %d, %d one way and %d, %d the other", score, a, b, a2, b2)
+		}
+	}
+}
+
+func TestNewEloDefaultKFactor(t *testing.T) {
+	if got := NewElo(0).KFactor; got != DefaultKFactor {
+		t.Errorf("KFactor = %v, want %v", got, DefaultKFactor)
+	}
+}
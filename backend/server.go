@@ -1,12 +1,19 @@
 package main
 
 import (
+	"backend/auth"
 	"backend/db"
+	"backend/game"
+	"backend/managers/room"
 	"backend/managers/server"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 var database *db.DB
@@ -24,10 +31,19 @@ func main() {
 	defer database.Close()
 
 	serverManager := server.GetServerManager()
+	serverManager.UseDatabase(database)
 
+	http.HandleFunc("/api/auth/register", handleRegister)
+	http.HandleFunc("/api/auth/login", handleLogin)
+	http.HandleFunc("/api/auth/guest", handleGuest)
+	http.HandleFunc("/api/auth/refresh", handleRefresh)
+	http.HandleFunc("/api/auth/logout", handleLogout)
 	http.HandleFunc("/api/leaderboard", handleLeaderboard)
 	http.HandleFunc("/api/player", handlePlayer)
-	http.HandleFunc("/api/test/update-stats", handleTestUpdateStats)
+	http.HandleFunc("/api/player/{username}/rating-history", handleRatingHistory)
+	http.HandleFunc("/api/games", handleGames)
+	http.HandleFunc("/api/games/{id}", handleGame)
+	http.HandleFunc("/api/rooms/live", handleLiveRooms)
 
 	serverManager.StartServer()
 }
@@ -70,33 +86,41 @@ func handleLeaderboard(w http.ResponseWriter, r *http.Request) {
 }
 
 ///////////////////////////////////////
-// handlePlayer handles the player API endpoint.
+// handlePlayer returns a player, by default the one signed in.
+// Players are created with their account, never by looking them up.
 ///////////////////////////////////////
 
 func handlePlayer(w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r) {
+		return
+	}
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	claims, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
 	username := r.URL.Query().Get("username")
 	if username == "" {
-		http.Error(w, "Username parameter is required", http.StatusBadRequest)
-		return
+		username = claims.Subject
 	}
 
 	player, err := database.GetPlayerByUsername(username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Player not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		player, err = database.CreateOrUpdatePlayer(username)
-		if err != nil {
-			log.Printf("Error creating player: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		log.Printf("Error getting player: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	if err := json.NewEncoder(w).Encode(player); err != nil {
 		log.Printf("Error encoding response: %v", err)
@@ -106,62 +130,202 @@ func handlePlayer(w http.ResponseWriter, r *http.Request) {
 }
 
 ///////////////////////////////////////
-// handleTestUpdateStats is a test endpoint to manually update player stats.
+// handleRatingHistory returns a player's rating after every rated game,
+// optionally limited to the from/to range (RFC 3339 or YYYY-MM-DD).
 ///////////////////////////////////////
 
-func handleTestUpdateStats(w http.ResponseWriter, r *http.Request) {
+func handleRatingHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	winner := r.URL.Query().Get("winner")
-	loser := r.URL.Query().Get("loser")
+	username := r.PathValue("username")
+	if username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
 
-	if winner == "" || loser == "" {
-		http.Error(w, "Winner and loser parameters are required", http.StatusBadRequest)
+	from, err := parseTimeParam(r.URL.Query().Get("from"), false)
+	if err != nil {
+		http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(r.URL.Query().Get("to"), true)
+	if err != nil {
+		http.Error(w, "Invalid to parameter", http.StatusBadRequest)
 		return
 	}
 
-	_, err := database.CreateOrUpdatePlayer(winner)
+	_, err = database.GetPlayerByUsername(username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Player not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Printf("Error creating winner: %v", err)
+		log.Printf("Error getting player: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = database.CreateOrUpdatePlayer(loser)
+	history, err := database.GetRatingHistory(username, from, to)
 	if err != nil {
-		log.Printf("Error creating loser: %v", err)
+		log.Printf("Error getting rating history: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	err = database.UpdateGameResult(winner, loser)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+///////////////////////////////////////
+// parseTimeParam parses an optional RFC 3339 timestamp or YYYY-MM-DD date.
+// A bare date used as an upper bound covers the whole day.
+///////////////////////////////////////
+
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+///////////////////////////////////////
+// handleGames lists a player's finished games, newest first.
+// Pass the returned next_cursor back as cursor to fetch the next page.
+///////////////////////////////////////
+
+func handleGames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "Username parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	limitStr := r.URL.Query().Get("limit")
+	limit := 10
+	if limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			limit = 10
+		}
+	}
+	if limit > 50 {
+		limit = 50
+	}
+
+	var cursor int64
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = strconv.ParseInt(cursorStr, 10, 64)
+		if err != nil || cursor <= 0 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Fetch one extra game to find out whether there is another page.
+	games, err := database.ListGamesByPlayer(username, limit+1, cursor)
 	if err != nil {
-		log.Printf("Error updating game result: %v", err)
+		log.Printf("Error listing games: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	nextCursor := ""
+	if len(games) > limit {
+		games = games[:limit]
+		nextCursor = strconv.FormatInt(games[len(games)-1].ID, 10)
+	}
+
+	response := map[string]interface{}{
+		"games":       games,
+		"next_cursor": nextCursor,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+}
 
-	winnerPlayer, err := database.GetPlayerByUsername(winner)
+///////////////////////////////////////
+// handleGame returns a finished game with its moves and the board
+// after every ply, so a client can step through a replay.
+///////////////////////////////////////
+
+func handleGame(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid game ID", http.StatusBadRequest)
+		return
+	}
+
+	storedGame, err := database.GetGame(id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Printf("Error getting winner: %v", err)
+		log.Printf("Error getting game: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	loserPlayer, err := database.GetPlayerByUsername(loser)
+	moves, err := database.GetGameMoves(id)
 	if err != nil {
-		log.Printf("Error getting loser: %v", err)
+		log.Printf("Error getting game moves: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	board := game.NewBoard()
+	states := make([][][]string, 0, len(moves))
+	for _, move := range moves {
+		if _, err := board.Play(move.Column); err != nil {
+			log.Printf("Error replaying game %d at ply %d: %v", id, move.Ply, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		states = append(states, board.Grid())
+	}
+
 	response := map[string]interface{}{
-		"success": true,
-		"winner":  winnerPlayer,
-		"loser":   loserPlayer,
+		"game":   storedGame,
+		"moves":  moves,
+		"states": states,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -173,3 +337,293 @@ func handleTestUpdateStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+///////////////////////////////////////
+// handleLiveRooms lists the games in progress that can be spectated.
+///////////////////////////////////////
+
+func handleLiveRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if err := json.NewEncoder(w).Encode(room.GetLiveRooms()); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+///////////////////////////////////////
+// AUTHENTICATION
+// Accounts sign in with a username and password, guests with nothing.
+// Both get a short-lived access token and a refresh token that can be
+// exchanged once for a new pair.
+///////////////////////////////////////
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type tokenResponse struct {
+	AccessToken  string   `json:"access_token"`
+	TokenType    string   `json:"token_type"`
+	ExpiresIn    int64    `json:"expires_in"` // seconds until the access token expires
+	RefreshToken string   `json:"refresh_token"`
+	User         *db.User `json:"user"`
+}
+
+///////////////////////////////////////
+// handleRegister creates an account with a password and signs it in.
+///////////////////////////////////////
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body credentials
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if err := auth.ValidateUsername(body.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := auth.HashPassword(body.Password)
+	if errors.Is(err, auth.ErrInvalidPassword) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	createUser(w, body.Username, hash, false)
+}
+
+///////////////////////////////////////
+// handleGuest creates a guest account. The guest may pick a username,
+// otherwise one is made up. A guest has no password and stays signed in
+// only as long as they keep refreshing their tokens.
+///////////////////////////////////////
+
+func handleGuest(w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body credentials
+	if r.ContentLength != 0 && !readJSON(w, r, &body) {
+		return
+	}
+	if body.Username == "" {
+		body.Username = auth.GuestUsername()
+	}
+	if err := auth.ValidateUsername(body.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	createUser(w, body.Username, "", true)
+}
+
+func createUser(w http.ResponseWriter, username, passwordHash string, guest bool) {
+	user, err := database.CreateUser(username, passwordHash, guest)
+	if errors.Is(err, db.ErrUsernameTaken) {
+		http.Error(w, "Username is already taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, refreshHash := auth.NewRefreshToken()
+	if err := database.StoreRefreshToken(user.ID, refreshHash, time.Now().Add(auth.Tokens.RefreshTTL)); err != nil {
+		log.Printf("Error storing refresh token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, http.StatusCreated, user, refreshToken)
+}
+
+///////////////////////////////////////
+// handleLogin signs in an account with its password. Guests cannot log
+// in, and a wrong username gets the same answer as a wrong password.
+///////////////////////////////////////
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body credentials
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	user, err := database.GetUserByUsername(body.Username)
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	passwordHash := ""
+	if user != nil {
+		passwordHash = user.PasswordHash
+	}
+	if !auth.CheckPassword(passwordHash, body.Password) {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	refreshToken, refreshHash := auth.NewRefreshToken()
+	if err := database.StoreRefreshToken(user.ID, refreshHash, time.Now().Add(auth.Tokens.RefreshTTL)); err != nil {
+		log.Printf("Error storing refresh token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, http.StatusOK, user, refreshToken)
+}
+
+///////////////////////////////////////
+// handleRefresh exchanges a refresh token for a new access token and a
+// new refresh token. The old refresh token stops working.
+///////////////////////////////////////
+
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body refreshRequest
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	refreshToken, refreshHash := auth.NewRefreshToken()
+	user, err := database.RotateRefreshToken(auth.HashRefreshToken(body.RefreshToken), refreshHash, time.Now().Add(auth.Tokens.RefreshTTL))
+	if errors.Is(err, db.ErrInvalidRefreshToken) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating refresh token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, http.StatusOK, user, refreshToken)
+}
+
+///////////////////////////////////////
+// handleLogout revokes a refresh token. Access tokens already issued
+// stay valid until they expire.
+///////////////////////////////////////
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body refreshRequest
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if err := database.RevokeRefreshToken(auth.HashRefreshToken(body.RefreshToken)); err != nil {
+		log.Printf("Error revoking refresh token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeTokens(w http.ResponseWriter, status int, user *db.User, refreshToken string) {
+	accessToken, _ := auth.Tokens.AccessToken(user.ID, user.Username, user.Guest, time.Now())
+	response := tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.Tokens.AccessTTL.Seconds()),
+		RefreshToken: refreshToken,
+		User:         user,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+///////////////////////////////////////
+// authenticate checks the request's bearer token. If it is missing or
+// invalid the request is answered with 401 and ok is false.
+///////////////////////////////////////
+
+func authenticate(w http.ResponseWriter, r *http.Request) (auth.Claims, bool) {
+	claims, err := auth.Tokens.Verify(auth.BearerToken(r), time.Now())
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return auth.Claims{}, false
+	}
+	return claims, true
+}
+
+// allowCORS lets browsers on other origins call the API with a bearer
+// token. It answers preflight requests itself and reports whether the
+// handler should go on.
+func allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	return true
+}
+
+// readJSON decodes the request body into v, answering 400 if it cannot.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"backend/signing"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+///////////////////////////////////////
+// SESSION TOKENS
+// A session token proves that a connection belongs to the player seated in
+// a room. It names one player in one room, expires after the signer's TTL
+// and is signed with HMAC-SHA256, so it cannot be forged or moved to
+// another seat. Signing is stateless: whoever accepts a token decides
+// whether its ID is still current, which is how a token is revoked.
+///////////////////////////////////////
+
+// DefaultTTL is how long a token is valid when no TTL is configured.
+const DefaultTTL = 24 * time.Hour
+
+var (
+	ErrInvalidToken = errors.New("invalid session token")
+	ErrTokenExpired = errors.New("session token has expired")
+)
+
+type Claims struct {
+	ID        string `json:"jti"`
+	Username  string `json:"sub"`
+	RoomID    string `json:"room"`
+	ExpiresAt int64  `json:"exp"` // unix seconds
+}
+
+type Signer struct {
+	key signing.Key
+	TTL time.Duration
+}
+
+///////////////////////////////////////
+// NewSigner signs with secret tokens that are valid for ttl, or for
+// DefaultTTL if ttl is not positive.
+///////////////////////////////////////
+
+func NewSigner(secret []byte, ttl time.Duration) Signer {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return Signer{key: signing.NewKey(secret), TTL: ttl}
+}
+
+///////////////////////////////////////
+// Issue returns a new token for username in roomID and its claims.
+///////////////////////////////////////
+
+func (s Signer) Issue(username, roomID string, now time.Time) (string, Claims) {
+	claims := Claims{
+		ID:        uuid.New().String(),
+		Username:  username,
+		RoomID:    roomID,
+		ExpiresAt: now.Add(s.TTL).Unix(),
+	}
+	encoded := signing.Encode(claims)
+	return encoded + "." + s.key.Sign(encoded), claims
+}
+
+///////////////////////////////////////
+// Verify checks the token's signature and expiry and returns its claims.
+// It does not know whether the token was revoked.
+///////////////////////////////////////
+
+func (s Signer) Verify(token string, now time.Time) (Claims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !s.key.Verify(encoded, signature) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := signing.Decode(encoded, &claims); err != nil || claims.ID == "" {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
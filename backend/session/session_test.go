@@ -0,0 +1,65 @@
+package session
+
+import (
+	"backend/signing"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestIssueVerifyRoundTrip(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	token, issued := NewSigner(testSecret, time.Hour).Issue("alice", "room-1", now)
+
+	// A signer built from the same secret, as after a restart, accepts it.
+	claims, err := NewSigner(testSecret, time.Hour).Verify(token, now.Add(59*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims != issued || claims.Username != "alice" || claims.RoomID != "room-1" {
+		t.Errorf("Verify = %+v, want %+v", claims, issued)
+	}
+	if claims.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Errorf("ExpiresAt = %d, want an hour after issue", claims.ExpiresAt)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := NewSigner(testSecret, time.Hour)
+	now := time.Unix(1_700_000_000, 0)
+	token, claims := signer.Issue("alice", "room-1", now)
+	encoded, signature, _ := strings.Cut(token, ".")
+
+	// Another player's claims under alice's signature.
+	stolen := claims
+	stolen.Username = "mallory"
+
+	tests := []struct {
+		name   string
+		signer Signer
+		token  string
+		now    time.Time
+		want   error
+	}{
+		{"tampered signature", signer, encoded + "." + signature[:len(signature)-1] + "A", now, ErrInvalidToken},
+		{"tampered claims", signer, signing.Encode(stolen) + "." + signature, now, ErrInvalidToken},
+		{"no signature", signer, encoded, now, ErrInvalidToken},
+		{"empty", signer, "", now, ErrInvalidToken},
+		{"other secret", NewSigner([]byte("fedcba9876543210fedcba9876543210"), time.Hour), token, now, ErrInvalidToken},
+		{"random secret", NewSigner(nil, time.Hour), token, now, ErrInvalidToken},
+		{"expired", signer, token, now.Add(time.Hour), ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		if _, err := tt.signer.Verify(tt.token, tt.now); err != tt.want {
+			t.Errorf("%s: Verify error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestNewSignerDefaultTTL(t *testing.T) {
+	if got := NewSigner(testSecret, 0).TTL; got != DefaultTTL {
+		t.Errorf("TTL = %v, want %v", got, DefaultTTL)
+	}
+}
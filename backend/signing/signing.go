@@ -0,0 +1,61 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+)
+
+///////////////////////////////////////
+// SIGNED TOKENS
+// Session tokens and access tokens are both a base64url JSON payload
+// followed by an HMAC-SHA256 signature. A Key signs and checks them.
+///////////////////////////////////////
+
+type Key struct {
+	secret []byte
+}
+
+///////////////////////////////////////
+// NewKey signs with secret. An empty secret is replaced by a random one,
+// so everything signed with it stops working when the server restarts.
+///////////////////////////////////////
+
+func NewKey(secret []byte) Key {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic("signing: cannot generate a secret: " + err.Error())
+		}
+	}
+	return Key{secret: secret}
+}
+
+// Sign returns the base64url HMAC-SHA256 signature of data.
+func (k Key) Sign(data string) string {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is data's signature, in constant time.
+func (k Key) Verify(data, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(k.Sign(data)))
+}
+
+// Encode returns v as base64url JSON.
+func Encode(v any) string {
+	payload, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// Decode reads base64url JSON written by Encode into v.
+func Decode(encoded string, v any) error {
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
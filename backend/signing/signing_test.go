@@ -0,0 +1,61 @@
+package signing
+
+import (
+	"testing"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSignRoundTrip(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+		N    int    `json:"n"`
+	}
+	encoded := Encode(payload{Name: "alice", N: 7})
+
+	// The same secret gives the same signature, as after a restart.
+	signature := NewKey(testSecret).Sign(encoded)
+	if !NewKey(testSecret).Verify(encoded, signature) {
+		t.Fatal("Verify rejected a signature made with the same secret")
+	}
+
+	var got payload
+	if err := Decode(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (payload{Name: "alice", N: 7}) {
+		t.Errorf("Decode = %+v, want alice 7", got)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key := NewKey(testSecret)
+	data := Encode("alice")
+	signature := key.Sign(data)
+
+	tests := []struct {
+		name            string
+		key             Key
+		data, signature string
+	}{
+		{"tampered signature", key, data, signature[:len(signature)-1] + "A"},
+		{"tampered data", key, Encode("mallory"), signature},
+		{"empty signature", key, data, ""},
+		{"other secret", NewKey([]byte("fedcba9876543210fedcba9876543210")), data, signature},
+		{"random secret", NewKey(nil), data, signature},
+	}
+	for _, tt := range tests {
+		if tt.key.Verify(tt.data, tt.signature) {
+			t.Errorf("%s: Verify accepted it", tt.name)
+		}
+	}
+}
+
+func TestDecodeRejectsGarbage(t *testing.T) {
+	var v map[string]any
+	for _, encoded := range []string{"not base64!", Encode("a string")[:3], ""} {
+		if err := Decode(encoded, &v); err == nil {
+			t.Errorf("Decode(%q) did not fail", encoded)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+//////////////////////////////////////////////////////////////
+//  AuthManager.ts
+//  This file keeps the player's access and refresh tokens.
+//  Players who have not registered play as guests under the
+//  username they entered.
+//////////////////////////////////////////////////////////////
+
+type StoredAuthType = {
+    username: string;
+    guest: boolean;
+    accessToken: string;
+    refreshToken: string;
+    expiresAt: number; // ms since epoch
+}
+
+type TokenResponseType = {
+    access_token: string;
+    token_type: string;
+    expires_in: number; // seconds
+    refresh_token: string;
+    user: { id: number; username: string; guest: boolean };
+}
+
+const STORAGE_KEY = 'connect4Auth';
+
+// Refresh this long before the access token expires
+const EXPIRY_MARGIN_MS = 30 * 1000;
+
+export class AuthManager {
+    ////////////////////
+    // Variables
+    ///////////////////
+    private static instance: AuthManager | null = null;
+    private serverUrl: string;
+    private auth: StoredAuthType | null = null;
+
+    ///////////////////////////////////////
+    // Singleton pattern to ensure only one instance of AuthManager exists
+    ///////////////////////////////////////
+
+    public static getInstance(): AuthManager {
+        if (AuthManager.instance === null) {
+            AuthManager.instance = new AuthManager(import.meta.env.VITE_SERVER_URL);
+        }
+        return AuthManager.instance;
+    }
+
+    constructor(serverUrl: string) {
+        this.serverUrl = serverUrl;
+
+        const saved = localStorage.getItem(STORAGE_KEY);
+        if (saved) {
+            try {
+                this.auth = JSON.parse(saved);
+            } catch (e) {
+                console.error('Failed to parse saved auth', e);
+            }
+        }
+    }
+
+    public get username(): string | null {
+        return this.auth?.username ?? null;
+    }
+
+    public async register(username: string, password: string): Promise<void> {
+        await this.post('/api/auth/register', { username, password });
+    }
+
+    public async login(username: string, password: string): Promise<void> {
+        await this.post('/api/auth/login', { username, password });
+    }
+
+    ///////////////////////////////////////
+    // Get a valid access token for username
+    // Refreshes the stored one if needed, or signs in as a guest
+    ///////////////////////////////////////
+
+    public async accessToken(username: string): Promise<string> {
+        if (this.auth && this.auth.username === username) {
+            if (Date.now() < this.auth.expiresAt - EXPIRY_MARGIN_MS) {
+                return this.auth.accessToken;
+            }
+            try {
+                await this.post('/api/auth/refresh', { refresh_token: this.auth.refreshToken });
+                return this.auth.accessToken;
+            } catch (error) {
+                console.error("Failed to refresh access token:", error);
+                this.clear();
+            }
+        }
+
+        await this.post('/api/auth/guest', { username });
+        return (this.auth as StoredAuthType).accessToken;
+    }
+
+    public async logout(): Promise<void> {
+        if (this.auth) {
+            try {
+                await fetch(this.serverUrl + '/api/auth/logout', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ refresh_token: this.auth.refreshToken })
+                });
+            } catch (error) {
+                console.error("Failed to log out:", error);
+            }
+        }
+        this.clear();
+    }
+
+    private clear(): void {
+        this.auth = null;
+        localStorage.removeItem(STORAGE_KEY);
+    }
+
+    private async post(path: string, body: object): Promise<void> {
+        const response = await fetch(this.serverUrl + path, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()).trim() || response.statusText);
+        }
+
+        const data: TokenResponseType = await response.json();
+        this.auth = {
+            username: data.user.username,
+            guest: data.user.guest,
+            accessToken: data.access_token,
+            refreshToken: data.refresh_token,
+            expiresAt: Date.now() + data.expires_in * 1000
+        };
+        localStorage.setItem(STORAGE_KEY, JSON.stringify(this.auth));
+    }
+}
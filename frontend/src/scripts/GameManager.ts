@@ -3,10 +3,12 @@
 //  This file manages the game state and WebSocket connection.
 ///////////////////////////////////////////////////////////////
 
-import type { GameRejoinedMessageType, GameStartedServerMessageType, GameUpdateServerMessageType, NewGameServerMessageType, PlayerDisconnectedMessageType, PlayerRejoinedMessageType, SocketClientMessageType, SocketServerMessageType } from "../types/SocketMessageTypes";
+import type { GameRejoinedMessageType, GameStartedServerMessageType, GameUpdateServerMessageType, NewGameServerMessageType, PlayerDisconnectedMessageType, PlayerRejoinedMessageType, SocketClientMessageType } from "../types/SocketMessageTypes";
 import { SocketManager } from "./SocketManager";
+import { AuthManager } from "./AuthManager";
+import { PROTOCOL_VERSION } from "../types/ProtocolTypes";
 import { PlayerManager } from "./PlayerManager";
-import type { ColorDiscFunctionType, DiscColorType, OpponentType, RoomIdType } from "../types/GameTypes";
+import type { BotDifficultyType, ColorDiscFunctionType, DiscColorType, OpponentType, RoomIdType } from "../types/GameTypes";
 
 export class GameManager {
     ///////////////////////////////
@@ -29,6 +31,7 @@ export class GameManager {
     // game state for reconnection
     private lastKnownRoomId: string | null = null;
     private lastKnownUsername: string | null = null;
+    private lastKnownSessionToken: string | null = null;
     private reconnectionTimer: number | null = null;
     private countdownInterval: ReturnType<typeof setInterval> | null = null;
 
@@ -64,7 +67,8 @@ export class GameManager {
         if (this.Player) {
             const gameState = {
                 roomId: this.Player.RoomId as string,
-                username: this.Player.Username
+                username: this.Player.Username,
+                sessionToken: this.lastKnownSessionToken
             };
             localStorage.setItem('connect4GameState', JSON.stringify(gameState));
             
@@ -84,6 +88,7 @@ export class GameManager {
                 const gameState = JSON.parse(savedState);
                 this.lastKnownRoomId = gameState.roomId;
                 this.lastKnownUsername = gameState.username;
+                this.lastKnownSessionToken = gameState.sessionToken ?? null;
             } catch (e) {
                 console.error('Failed to parse saved game state', e);
             }
@@ -97,6 +102,7 @@ export class GameManager {
         localStorage.removeItem('connect4GameState');
         this.lastKnownRoomId = null;
         this.lastKnownUsername = null;
+        this.lastKnownSessionToken = null;
     }
 
     public SetUpPlayer(ColorDiscFunction: ColorDiscFunctionType, DiscColor: DiscColorType, Opponent: OpponentType, RoomId: RoomIdType, Username: string) {
@@ -106,6 +112,15 @@ export class GameManager {
         this.saveGameState();
     }
 
+    ///////////////////////////////////////
+    // WebSocket URL signed in as username
+    // Browsers cannot send headers with a WebSocket, so the access token goes in the query
+    ///////////////////////////////////////
+    private async socketUrl(username: string): Promise<string> {
+        const token = await AuthManager.getInstance().accessToken(username);
+        return this.wsUrl + "?access_token=" + encodeURIComponent(token) + "&version=" + PROTOCOL_VERSION;
+    }
+
     ///////////////////////////////////////
     // Place Disc
     // This method sends a message to the server to place a disc
@@ -123,25 +138,44 @@ export class GameManager {
             data: {
                 "action": "place_disc",
                 "column": colIdx,
-                "row": rowIdx,
                 "room_id": this.Player.RoomId,
                 "player_color": this.Player.DiscColor
             }
-        });
+        } as SocketClientMessageType);
         
         this.Player.PlaceDisc(colIdx, rowIdx);
         this.Player.Turn = false;
     }
 
+    ///////////////////////////////////////
+    // Game Action
+    // Sends resign, a draw offer or answer, or abort for the current game
+    ///////////////////////////////////////
+    public send_game_action(action: "resign" | "offer_draw" | "accept_draw" | "decline_draw" | "abort" | "takeback_request" | "takeback_accept" | "takeback_decline") {
+        if (!this.Player) {
+            console.log("Player not initialized");
+            return;
+        }
+
+        this.socketManager.sendMessage({
+            type: "game_update",
+            username: this.Player.Username,
+            data: {
+                "action": action,
+                "room_id": this.Player.RoomId
+            }
+        } as SocketClientMessageType);
+    }
+
     ///////////////////////////////////////
     // Create a new game
     // This method sends a message to the server to create a new game
     ///////////////////////////////////////
 
-    public async new_game_request_handler(username: string) {
+    public async new_game_request_handler(username: string, difficulty?: BotDifficultyType) {
         console.log("new_game_request_handler", username)
         if (!this.socketManager.isConnected) {
-            await this.socketManager.connect(this.wsUrl + "?username=" + encodeURIComponent(username));
+            await this.socketManager.connect(await this.socketUrl(username));
             this.listen_server_for_messages();
         }
         if (this.socketManager.isConnected) {
@@ -149,7 +183,7 @@ export class GameManager {
             this.socketManager.sendMessage({
                 type: "new_game",
                 username: username,
-                data: {}
+                data: difficulty ? { difficulty } : {}
             } as SocketClientMessageType);
         }
     }
@@ -180,7 +214,7 @@ export class GameManager {
     public async reconnectToGame(username: string, roomId: string): Promise<void> {
         try {
             if (!this.socketManager.isConnected) {
-                await this.socketManager.connect(this.wsUrl + "?username=" + encodeURIComponent(username));
+                await this.socketManager.connect(await this.socketUrl(username));
                 this.listen_server_for_messages();
             }
             
@@ -191,7 +225,8 @@ export class GameManager {
                     type: "reconnect",
                     username: username,
                     data: {
-                        room_id: roomId
+                        room_id: roomId,
+                        session_token: this.lastKnownSessionToken ?? ""
                     }
                 } as SocketClientMessageType);
             }
@@ -211,7 +246,7 @@ export class GameManager {
     public player_disconnected_handler(message: PlayerDisconnectedMessageType): void {
         console.log("Player disconnected:", message);
         
-        this.SetStatusMessage(message.data.message);
+        this.SetStatusMessage(message.data.message ?? "");
         
         let countdown = 30;
         this.SetCountdown(countdown);
@@ -257,6 +292,8 @@ export class GameManager {
         
         this.SetReconnecting(false);
         this.SetGameStarted(true);
+        // The token just used is revoked, keep the new one for the next reconnect
+        this.lastKnownSessionToken = message.data.session_token ?? null;
         
         if (this.ColorDiscFunction) {
             this.SetUpPlayer(
@@ -291,7 +328,7 @@ export class GameManager {
     ///////////////////////////////////////
 
     public new_game_response_handler(message: NewGameServerMessageType) {
-        console.log("Room ID : ", message.data.room_id)
+        console.log("Searching for a game at rating", message.data.rating)
     }
     
     ////////////////////////////////////////
@@ -302,6 +339,7 @@ export class GameManager {
     public game_started_handler(message: GameStartedServerMessageType) {
         console.log("Game started", message)
         this.SetGameStarted(true)
+        this.lastKnownSessionToken = message.data.session_token ?? null
         setTimeout(() => {
         if (this.ColorDiscFunction) {
             this.SetUpPlayer(this.ColorDiscFunction, message.data.player_color, message.data.opponent_type, message.data.room_id, message.data.player_username)
@@ -327,7 +365,9 @@ export class GameManager {
         console.log("Game update received", message);
         
         if (this.Player) {
-            this.SetGridData(message.data.grid_data);
+            if (message.data.grid_data) {
+                this.SetGridData(message.data.grid_data);
+            }
             
             const isMyTurn = message.data.current_turn === this.Player.Username;
             this.Player.Turn = isMyTurn;
@@ -349,10 +389,10 @@ export class GameManager {
     // Game over handler
     // This method handles the game over message from the server
     ////////////////////////////////////////
-    public game_over_handler(message: SocketServerMessageType) {
+    public game_over_handler(message: GameUpdateServerMessageType) {
         console.log("Game over", message);
         if (this.Player) {
-            const winner = message.data.winner as string;
+            const winner = message.data.winner;
             if (winner === this.Player.Username) {
                 alert("You won!");
             } else if (winner === "draw") {
@@ -400,13 +440,14 @@ export class GameManager {
                         this.game_rejoined_handler(message);
                         break;
                     case "error":
-                        console.error("Error:", message.data.error);
+                        console.error("Error:", message.data.code, message.data.error);
                         alert("Error: " + message.data.error);
                         break;
                     case "info":
                         console.log("Info:", message.data.info);
                         break;
                     case "connection_ack":
+                    case "ack":
                         break;
                     default:
                         console.warn("Unknown message type:", message.type);
@@ -4,6 +4,7 @@
 /////////////////////////////////////////////////////////////
 
 import type { SocketClientMessageType } from "../types/SocketMessageTypes";
+import { PROTOCOL_VERSION } from "../types/ProtocolTypes";
 
 export class SocketManager {
     ////////////////////
@@ -12,6 +13,7 @@ export class SocketManager {
     public wsClient: WebSocket | null = null;
     public isConnected: Boolean = false;
     private reconnectTimeout: number | null = null;
+    private lastRequestId: number = 0;
     
     ////////////////////////////////////////////
     // Connect to the WebSocket server
@@ -91,11 +93,14 @@ export class SocketManager {
     ////////////////////////////////////////////
     // Send a message through the WebSocket
     // @param message - The message to send
+    // @returns The request_id the server's ack or error will carry
     ////////////////////////////////////////////
 
-    public sendMessage(message: SocketClientMessageType): void {
+    public sendMessage(message: SocketClientMessageType): string {
         if (this.wsClient && this.wsClient.readyState === WebSocket.OPEN) {
-            this.wsClient.send(JSON.stringify(message));
+            const requestId = String(++this.lastRequestId);
+            this.wsClient.send(JSON.stringify({ ...message, version: PROTOCOL_VERSION, request_id: requestId }));
+            return requestId;
         } else {
             console.error("WebSocket is not open. Unable to send message.");
             throw new Error("WebSocket is not open. Unable to send message.");
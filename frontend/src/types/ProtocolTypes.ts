@@ -0,0 +1,319 @@
+// Code generated by cmd/protogen from backend/managers/types. DO NOT EDIT.
+
+export const PROTOCOL_VERSION = 1;
+export const MIN_PROTOCOL_VERSION = 1;
+
+export type PlayerColor = "red" | "blue" | "";
+export type BotDifficulty = "easy" | "medium" | "hard" | "perfect";
+export type TimeControlMode = "fischer" | "per_move" | "correspondence";
+export type GameAction = "place_disc" | "resign" | "offer_draw" | "accept_draw" | "decline_draw" | "abort" | "takeback_request" | "takeback_accept" | "takeback_decline";
+export type Emote = "good_game" | "good_luck" | "oops" | "thinking" | "well_played" | "wow";
+export type ErrorCode =
+    | "INVALID_MESSAGE"
+    | "UNKNOWN_MESSAGE_TYPE"
+    | "UNSUPPORTED_VERSION"
+    | "USERNAME_MISMATCH"
+    | "INVALID_ACTION"
+    | "INVALID_ROOM_ID"
+    | "ROOM_NOT_FOUND"
+    | "INTERNAL_ERROR"
+    | "INVALID_DIFFICULTY"
+    | "INVALID_OPPONENT"
+    | "INVALID_BOT_AFTER"
+    | "INVALID_TIME_CONTROL"
+    | "ALREADY_SEARCHING"
+    | "NOT_SEARCHING"
+    | "INVITE_NOT_FOUND"
+    | "OWN_INVITE"
+    | "NO_INVITE"
+    | "NO_GAME_IN_PROGRESS"
+    | "PLAYING_IN_ROOM"
+    | "NOT_SPECTATING"
+    | "GAME_NOT_IN_PROGRESS"
+    | "NOT_SEATED"
+    | "NOT_YOUR_TURN"
+    | "INVALID_COLUMN"
+    | "COLUMN_FULL"
+    | "COLUMN_OUT_OF_RANGE"
+    | "COLOR_MISMATCH"
+    | "TIME_EXPIRED"
+    | "INVALID_MOVE"
+    | "NO_DRAW_OFFER"
+    | "DRAW_OFFER_OPEN"
+    | "BOT_DECLINES_DRAW"
+    | "ABORT_TOO_LATE"
+    | "TAKEBACKS_DISABLED"
+    | "NOTHING_TO_TAKE_BACK"
+    | "TAKEBACK_LIMIT"
+    | "TAKEBACK_OFFER_OPEN"
+    | "NO_TAKEBACK_OFFER"
+    | "NO_FINISHED_GAME"
+    | "IN_ANOTHER_GAME"
+    | "REMATCH_EXPIRED"
+    | "REMATCH_OFFER_OPEN"
+    | "NO_REMATCH_OFFER"
+    | "OPPONENT_LEFT"
+    | "OPPONENT_BUSY"
+    | "CHAT_EMPTY"
+    | "CHAT_TOO_LONG"
+    | "UNKNOWN_EMOTE"
+    | "RATE_LIMITED"
+    | "CHAT_CLOSED"
+    | "NOT_DISCONNECTED"
+    | "REJOIN_EXPIRED"
+    | "INVALID_SESSION"
+    | "SESSION_EXPIRED"
+    | "SESSION_REVOKED";
+
+export type TimeControl =
+    | { mode: "fischer"; initial: number; increment: number }
+    | { mode: "per_move"; seconds: number }
+    | { mode: "correspondence"; days: number };
+
+export interface NewGameRequest {
+    difficulty?: BotDifficulty;
+    opponent?: "any" | "human";
+    bot_after?: number;
+    time_control?: TimeControl;
+}
+
+export interface GameUpdateRequest {
+    room_id: string;
+    action: GameAction;
+    column?: number;
+    player_color?: PlayerColor;
+}
+
+export interface ReconnectRequest {
+    room_id: string;
+    session_token: string;
+}
+
+export type EmptyRequest = Record<string, never>;
+
+export interface CreatePrivateGameRequest {
+    time_control?: TimeControl;
+    rated?: boolean;
+}
+
+export interface JoinPrivateGameRequest {
+    invite_code: string;
+}
+
+export interface RoomRequest {
+    room_id: string;
+}
+
+export interface ChatRequest {
+    room_id: string;
+    text: string;
+}
+
+export interface EmoteRequest {
+    room_id: string;
+    emote: Emote;
+}
+
+export interface PingRequest {
+    sent_at?: number;
+}
+
+export interface ConnectionAckData {
+    username: string;
+    guest?: boolean;
+    version: number;
+    min_version: number;
+    max_version: number;
+}
+
+export interface AckData {
+    request_id: string;
+    type: string;
+}
+
+export interface ErrorData {
+    code: ErrorCode;
+    error: string;
+    request_id?: string;
+    action?: string;
+    room_id?: string;
+    status?: string;
+    current_turn?: string;
+    grid_data?: string[][];
+    column?: number;
+}
+
+export interface InfoData {
+    info: string;
+}
+
+export interface NewGameResponseData {
+    status: string;
+    rating: number;
+    human_only: boolean;
+    bot_after: number;
+    bot_difficulty: BotDifficulty;
+    time_control: TimeControl;
+}
+
+export interface GameStateData {
+    room_id: string;
+    status: string;
+    opponent_type?: "human" | "bot";
+    current_turn: string;
+    total_players: number;
+    players: string[];
+    grid_data: string[][];
+    player_username?: string;
+    player_color?: PlayerColor;
+    opponent_username?: string;
+    opponent_color?: PlayerColor;
+    bot_difficulty?: BotDifficulty;
+    rated: boolean;
+    clock?: ClockState;
+    chat?: ChatLine[];
+    latency_ms?: Record<string, number>;
+    session_token?: string;
+}
+
+export interface ClockState {
+    time_control: TimeControl;
+    remaining_ms: Record<string, number>;
+    running: string;
+}
+
+export interface ChatLine {
+    kind: "chat" | "emote";
+    username: string;
+    text?: string;
+    emote?: Emote;
+    sent_at: string;
+}
+
+export interface GameUpdateData {
+    room_id: string;
+    status: string;
+    current_turn?: string;
+    grid_data?: string[][];
+    clock?: ClockState;
+    winner?: string;
+    draw?: boolean;
+    termination?: string;
+    rating_changes?: Record<string, RatingChange>;
+    message?: string;
+    takeback?: boolean;
+    latency_ms?: Record<string, number>;
+}
+
+export interface RatingChange {
+    username: string;
+    before: number;
+    after: number;
+    delta: number;
+}
+
+export interface PlayerEventData {
+    room_id?: string;
+    username: string;
+    message?: string;
+}
+
+export interface PrivateGameData {
+    room_id: string;
+    invite_code: string;
+    expires_at?: string;
+    status?: string;
+    time_control?: TimeControl;
+    rated?: boolean;
+    message?: string;
+}
+
+export interface SpectateData {
+    room_id: string;
+    status: string;
+    opponent_type: "human" | "bot";
+    bot_difficulty?: BotDifficulty;
+    current_turn: string;
+    players: Record<string, PlayerColor>;
+    grid_data: string[][];
+    moves?: GameMove[];
+    spectators: number;
+    started_at: string;
+    clock?: ClockState;
+    chat?: ChatLine[];
+    latency_ms?: Record<string, number>;
+}
+
+export interface GameMove {
+    ply: number;
+    column: number;
+    player: string;
+    color: PlayerColor;
+    played_at: string;
+}
+
+export type EmptyData = Record<string, never>;
+
+export interface RematchOfferedData {
+    room_id: string;
+    username: string;
+    expires_at: string;
+}
+
+export interface ChatData {
+    room_id: string;
+    line: ChatLine;
+}
+
+export interface PongData {
+    sent_at?: number;
+    server_time: string;
+    latency_ms: number;
+}
+
+export type ClientMessage =
+    | { type: "new_game"; version?: number; request_id?: string; username?: string; data: NewGameRequest }
+    | { type: "game_update"; version?: number; request_id?: string; username?: string; data: GameUpdateRequest }
+    | { type: "reconnect"; version?: number; request_id?: string; username?: string; data: ReconnectRequest }
+    | { type: "cancel_search"; version?: number; request_id?: string; username?: string; data: EmptyRequest }
+    | { type: "create_private_game"; version?: number; request_id?: string; username?: string; data: CreatePrivateGameRequest }
+    | { type: "join_private_game"; version?: number; request_id?: string; username?: string; data: JoinPrivateGameRequest }
+    | { type: "cancel_private_game"; version?: number; request_id?: string; username?: string; data: EmptyRequest }
+    | { type: "spectate"; version?: number; request_id?: string; username?: string; data: RoomRequest }
+    | { type: "stop_spectating"; version?: number; request_id?: string; username?: string; data: EmptyRequest }
+    | { type: "rematch_offer"; version?: number; request_id?: string; username?: string; data: RoomRequest }
+    | { type: "rematch_accept"; version?: number; request_id?: string; username?: string; data: RoomRequest }
+    | { type: "chat_message"; version?: number; request_id?: string; username?: string; data: ChatRequest }
+    | { type: "emote"; version?: number; request_id?: string; username?: string; data: EmoteRequest }
+    | { type: "ping"; version?: number; request_id?: string; username?: string; data: PingRequest };
+
+export type ClientMessageOf<T extends ClientMessage["type"]> = Extract<ClientMessage, { type: T }>;
+
+export type ServerMessage =
+    | { type: "connection_ack"; version: number; data: ConnectionAckData }
+    | { type: "ack"; version: number; data: AckData }
+    | { type: "error"; version: number; data: ErrorData }
+    | { type: "info"; version: number; data: InfoData }
+    | { type: "new_game_response"; version: number; data: NewGameResponseData }
+    | { type: "game_started"; version: number; data: GameStateData }
+    | { type: "game_rejoined"; version: number; data: GameStateData }
+    | { type: "game_joined"; version: number; data: GameStateData }
+    | { type: "game_update"; version: number; data: GameUpdateData }
+    | { type: "player_disconnected"; version: number; data: PlayerEventData }
+    | { type: "player_rejoined"; version: number; data: PlayerEventData }
+    | { type: "private_game_created"; version: number; data: PrivateGameData }
+    | { type: "private_game_expired"; version: number; data: PrivateGameData }
+    | { type: "private_game_cancelled"; version: number; data: PrivateGameData }
+    | { type: "spectate_started"; version: number; data: SpectateData }
+    | { type: "spectate_stopped"; version: number; data: EmptyData }
+    | { type: "draw_offered"; version: number; data: PlayerEventData }
+    | { type: "draw_declined"; version: number; data: PlayerEventData }
+    | { type: "takeback_requested"; version: number; data: PlayerEventData }
+    | { type: "takeback_declined"; version: number; data: PlayerEventData }
+    | { type: "rematch_offered"; version: number; data: RematchOfferedData }
+    | { type: "rematch_withdrawn"; version: number; data: PlayerEventData }
+    | { type: "chat_message"; version: number; data: ChatData }
+    | { type: "emote"; version: number; data: ChatData }
+    | { type: "pong"; version: number; data: PongData };
+
+export type ServerMessageOf<T extends ServerMessage["type"]> = Extract<ServerMessage, { type: T }>;
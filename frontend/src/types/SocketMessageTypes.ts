@@ -1,87 +1,53 @@
-import type { DiscColorType, OpponentType } from "./GameTypes";
-export interface SocketClientMessageType {
-    type: "new_game" | "join_game" | "game_update" | "game_over" | "connection_ack" | "reconnect";
-    username: string;
-    data: any;
-}
-export interface SocketServerMessageType {
-    type: "new_game" | "join_game" | "game_update" | "game_over" | "connection_ack" | "error" | "info" | "player_disconnected" | "player_rejoined" | "game_rejoined";
-    success?: Boolean
-    room_id?: string | null;
-    socket_id?: string | null;
-    player_id?: string | null;
-    data?: any;
-}
+/////////////////////////////////////////////////////////////
+//  SocketMessageTypes.ts
+//  Names the app uses for websocket messages. The message shapes
+//  themselves are generated from the server in ProtocolTypes.ts.
+/////////////////////////////////////////////////////////////
 
-export interface NewGameServerMessageType {
-    type: "new_game_response"
-    data: {
-        room_id: string;
-        status: string;
-        current_turn: string;
-        total_players: number;
-        players: string[];
-        grid_data: string[][];
-    }
-}
+import type {
+    ChatLine,
+    GameStateData,
+    ClientMessage,
+    ClientMessageOf,
+    ClockState,
+    Emote,
+    RatingChange,
+    ServerMessage,
+    ServerMessageOf,
+    TimeControl,
+} from "./ProtocolTypes";
 
-export interface GameStartedServerMessageType {
-    type: "game_started"
-    data: {
-        player_username: string;
-        player_color: DiscColorType;
-        opponent_color : DiscColorType;
-        opponent_username: string;
-        opponent_type: OpponentType;
-        room_id: string;
-        status: string;
-        current_turn: string;
-        total_players: number;
-        players: string[];
-        grid_data: string[][];
-    }
-}
+export type SocketClientMessageType = ClientMessage
+export type SocketServerMessageType = ServerMessage
 
-export interface GameUpdateServerMessageType {
-    type: "game_update"
-    data: {
-        room_id: string;
-        status: string;
-        current_turn: string;
-        grid_data: string[][];
-        winner?: string;
-        message?: string;
-    }
-}
+export type NewGameClientMessageType = ClientMessageOf<"new_game">
+export type GameUpdateClientMessageType = ClientMessageOf<"game_update">
 
-export interface PlayerDisconnectedMessageType {
-    type: "player_disconnected"
-    data: {
-        username: string;
-        message: string;
+export type ConnectionAckServerMessageType = ServerMessageOf<"connection_ack">
+export type ErrorServerMessageType = ServerMessageOf<"error">
+export type NewGameServerMessageType = ServerMessageOf<"new_game_response">
+export type PrivateGameServerMessageType = ServerMessageOf<"private_game_created" | "private_game_expired" | "private_game_cancelled">
+export type ChatServerMessageType = ServerMessageOf<"chat_message" | "emote">
+export type SpectateStartedServerMessageType = ServerMessageOf<"spectate_started">
+// game_started and game_rejoined always name both seats, which the
+// generated GameStateData leaves optional because game_joined does not.
+type SeatedGameState = {
+    data: GameStateData & {
+        player_username: string;
+        player_color: "red" | "blue";
+        opponent_username: string;
+        opponent_type: "human" | "bot";
     }
 }
 
-export interface PlayerRejoinedMessageType {
-    type: "player_rejoined"
-    data: {
-        username: string;
-    }
-}
+export type GameStartedServerMessageType = ServerMessageOf<"game_started"> & SeatedGameState
+export type GameUpdateServerMessageType = ServerMessageOf<"game_update">
+export type PlayerDisconnectedMessageType = ServerMessageOf<"player_disconnected">
+export type PlayerRejoinedMessageType = ServerMessageOf<"player_rejoined">
+export type GameRejoinedMessageType = ServerMessageOf<"game_rejoined"> & SeatedGameState
 
-export interface GameRejoinedMessageType {
-    type: "game_rejoined"
-    data: {
-        player_username: string;
-        player_color: DiscColorType;
-        opponent_color: DiscColorType;
-        opponent_username: string;
-        opponent_type: OpponentType;
-        room_id: string;
-        status: string;
-        current_turn: string;
-        total_players: number;
-        players: string[];
-        grid_data: string[][];
-    }
-}
\ No newline at end of file
+export type EmoteType = Emote
+export type ChatLineType = ChatLine
+export type RatingChangeType = RatingChange
+export type TimeControlType = TimeControl
+export type ClockType = ClockState